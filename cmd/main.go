@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
@@ -10,12 +12,20 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/api"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/broadcaster"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/config"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/db"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/global"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/jwt"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/leaderboard"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/matchmaking"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/outbox"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/presence"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/problems"
 	localstate "github.com/lijuuu/ChallengeWssManagerService/internal/local"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/rematch"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/repo"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/service"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/wss"
@@ -23,7 +33,9 @@ import (
 	wsshandler "github.com/lijuuu/ChallengeWssManagerService/internal/wss/handlers"
 	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
 	challengepb "github.com/lijuuu/GlobalProtoXcode/ChallengeService"
+	problemPb "github.com/lijuuu/GlobalProtoXcode/ProblemsService"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 func main() {
@@ -50,25 +62,113 @@ func main() {
 
 	// Initialize repositories
 	mongoRepo := repo.NewMongoRepository(mongoInstance, "challengeDB")
+	mongoRepo.SetWritePolicy(repo.MongoWritePolicy{
+		W:       cfg.MongoWriteConcern,
+		Journal: cfg.MongoWriteJournal,
+		Timeout: time.Duration(cfg.MongoWriteTimeoutSeconds) * time.Second,
+	})
+	// Ratings and results are applied once per challenge only under these unique indexes
+	if err := mongoRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to ensure MongoDB indexes: %v; remove the duplicates and restart", err)
+	}
 	redisRepo := repo.NewRedisRepository(redisClient)
+	redisRepo.SetEventStreamMaxLen(int64(cfg.WSResumeMaxEvents))
+	redisRepo.SetTxMaxAttempts(cfg.RedisTxMaxAttempts)
+	redisRepo.SetExpiryGrace(time.Duration(cfg.ChallengeExpiryGraceMinutes) * time.Minute)
+
+	// Convert challenges stored as JSON strings by earlier versions to the hash layout
+	if migrated, err := redisRepo.MigrateChallengeLayout(context.Background()); err != nil {
+		log.Printf("Warning: Failed to migrate challenge layout: %v", err)
+	} else if migrated > 0 {
+		log.Printf("Migrated %d challenges to the hash layout", migrated)
+	}
+
+	// Give challenges written before TTLs were introduced an expiry
+	if updated, err := redisRepo.EnsureChallengeExpiry(context.Background()); err != nil {
+		log.Printf("Warning: Failed to set challenge expiry: %v", err)
+	} else if updated > 0 {
+		log.Printf("Set expiry on %d challenges", updated)
+	}
 
 	// Initialize local state manager
 	localStateManager := localstate.NewLocalStateManager()
+	localStateManager.SetKickOlderSessions(cfg.WSKickOlderSessions)
+
+	// Initialize broadcaster; the redis backend fans broadcasts out across replicas
+	challengeBroadcaster, err := broadcaster.New(cfg.BroadcastBackend, redisClient, localStateManager)
+	if err != nil {
+		log.Fatalf("Failed to initialize broadcaster: %v", err)
+	}
+	localStateManager.SetSubscriber(challengeBroadcaster)
+	log.Printf("Broadcasting challenge events with the %s backend", cfg.BroadcastBackend)
+
+	// Initialize presence registry, heartbeating this node's connections
+	nodeID := cfg.NodeID
+	if nodeID == "" {
+		hostname, _ := os.Hostname()
+		nodeID = hostname + "-" + uuid.NewString()[:8]
+	}
+	presenceRegistry := presence.NewRegistry(redisRepo, localStateManager, nodeID, time.Duration(cfg.PresenceTTLSeconds)*time.Second)
+	go presenceRegistry.Run(context.Background())
+	log.Printf("Recording presence as node %s", nodeID)
 
 	// Initialize leaderboard service
 	leaderboardManager := leaderboard.NewLeaderboardManager(cfg.RedisURL, cfg.RedisPassword)
 
+	// Initialize matchmaker
+	matchmaker := matchmaking.NewMatchmaker(cfg.MatchmakingUseRating)
+
+	// Problems for matches and rematches are picked from the problems service
+	problemsConn, err := grpc.NewClient(cfg.ProblemsGRPCURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to create problems service client: %v", err)
+	}
+	problemSelector := problems.NewSelector(problemPb.NewProblemsServiceClient(problemsConn))
+
+	// Initialize rematch manager
+	rematches := rematch.NewManager()
+
+	// Initialize the outbox persisting finished challenges to MongoDB
+	persistOutbox := outbox.NewWorker(redisRepo, outbox.Config{
+		MaxAttempts: cfg.OutboxMaxAttempts,
+		BaseBackoff: time.Duration(cfg.OutboxBaseBackoffSeconds) * time.Second,
+		MaxBackoff:  time.Duration(cfg.OutboxMaxBackoffSeconds) * time.Second,
+	})
+
 	// Initialize WebSocket state with both repositories and local state manager
 	websocketState := &global.State{
-		Redis:      redisRepo,
-		Mongo:      mongoRepo,
-		LocalState: localStateManager,
-		JwtManager: jwtManager,
+		Redis:              redisRepo,
+		Mongo:              mongoRepo,
+		LocalState:         localStateManager,
+		Broadcaster:        challengeBroadcaster,
+		Presence:           presenceRegistry,
+		LeaderboardManager: leaderboardManager,
+		JwtManager:         jwtManager,
+		Matchmaker:         matchmaker,
+		Rematches:          rematches,
+		Outbox:             persistOutbox,
+		Problems:           problemSelector,
 	}
 
 	// Initialize service with both repositories and WebSocket state
 	challengeService := service.NewChallengeService(websocketState)
 
+	// Start matchmaking loop
+	matchmaker.SetMatchHandler(challengeService.HandleMatch)
+	go matchmaker.Run(context.Background())
+
+	// Create rematch challenges once all participants accept
+	rematches.SetReadyHandler(challengeService.HandleRematch)
+
+	// Retry persistence of finished challenges until it succeeds or is dead-lettered
+	persistOutbox.SetHandler(challengeService.PersistChallenge)
+	go persistOutbox.Run(context.Background())
+
+	// Persist and clean up challenges whose Redis TTL runs out
+	if cfg.ChallengeExpiryListener {
+		go challengeService.RunExpiryListener(context.Background())
+	}
+
 	// Start gRPC server in a goroutine
 	go runGRPCServer(&cfg, challengeService)
 
@@ -84,14 +184,34 @@ func main() {
 			}
 		}
 
+		// Connections authenticated at upgrade need no per-message token
+		if token == "" && ctx.Claims != nil {
+			if exp := ctx.Claims.ExpiresAt; exp != nil && time.Now().After(exp.Time) {
+				broadcasts.ReplyError(ctx, ctx.EventType, wsstypes.ErrCodeAuth, "Invalid or expired token")
+				return errors.New("connection token expired")
+			}
+			ctx.UserID = ctx.Claims.UserID
+			return nil
+		}
+
 		if token == "" {
-			return broadcasts.SendErrorWithType(ctx.Conn, "AUTH_ERROR", "Authentication token required", nil)
+			broadcasts.ReplyError(ctx, ctx.EventType, wsstypes.ErrCodeAuth, "Authentication token required")
+			return errors.New("authentication token required")
 		}
 
 		// Validate JWT token
 		claims, err := jwtManager.ValidateToken(token)
 		if err != nil {
-			return broadcasts.SendErrorWithType(ctx.Conn, "AUTH_ERROR", "Invalid or expired token", nil)
+			broadcasts.ReplyError(ctx, ctx.EventType, wsstypes.ErrCodeAuth, "Invalid or expired token")
+			return fmt.Errorf("invalid or expired token: %w", err)
+		}
+
+		// Token must belong to the identity bound to this connection
+		if ctx.Session != nil {
+			if err := ctx.Session.CheckClaim(claims.UserID, claims.ChallengeID); err != nil {
+				broadcasts.ReplyError(ctx, ctx.EventType, wsstypes.ErrCodeAuth, "Token does not match this connection")
+				return err
+			}
 		}
 
 		// Store claims in context for handler use
@@ -108,11 +228,7 @@ func main() {
 			rand.Seed(time.Now().UnixNano())
 			randDuration := time.Duration(rand.Intn(1000)) * time.Millisecond
 			time.Sleep(randDuration)*/
-		return broadcasts.SendJSON(wc.Conn, map[string]interface{}{
-			"type":    wsstypes.PING_SERVER,
-			"status":  "ok",
-			"message": "pong",
-		})
+		return broadcasts.Reply(wc, wsstypes.PING_SERVER, wsstypes.PongPayload{Message: "pong"})
 	})
 
 	//join challenge - no authentication needed (generates token)
@@ -124,11 +240,43 @@ func main() {
 	//get leaderboard - requires authentication
 	dispatcher.RegisterWithMiddleware(wsstypes.CURRENT_LEADERBOARD, wsshandler.NewGetLeaderboardHandler(leaderboardManager), jwtMiddleware)
 
-	http.HandleFunc("/ws", wss.WsHandler(dispatcher, websocketState))
+	//queue for matchmaking - authenticates with the API gateway token like JOIN_CHALLENGE
+	dispatcher.Register(wsstypes.QUEUE_MATCH, wsshandler.QueueMatchHandler)
+
+	//rematch - requires authentication with the finished challenge's token
+	dispatcher.RegisterWithMiddleware(wsstypes.REMATCH_REQUEST, wsshandler.RematchRequestHandler, jwtMiddleware)
+	dispatcher.RegisterWithMiddleware(wsstypes.REMATCH_ACCEPT, wsshandler.RematchAcceptHandler, jwtMiddleware)
+
+	//resume after reconnect - requires authentication
+	dispatcher.RegisterWithMiddleware(wsstypes.RESUME, wsshandler.ResumeHandler, jwtMiddleware)
+
+	//who is online in the challenge - requires authentication
+	dispatcher.RegisterWithMiddleware(wsstypes.PRESENCE, wsshandler.PresenceHandler, jwtMiddleware)
+
+	// Routes live on their own mux: importing expvar registers /debug/vars on the
+	// default one, which must not be reachable on the public port
+	mux := http.NewServeMux()
+	wsOptions, err := wss.OptionsFromConfig(&cfg)
+	if err != nil {
+		log.Fatalf("Invalid WebSocket configuration: %v", err)
+	}
+	mux.HandleFunc("/ws", wss.WsHandler(dispatcher, websocketState, wsOptions))
+
+	// HTTP query endpoints
+	mux.HandleFunc("/ratings/history", api.RatingHistoryHandler(challengeService))
+	mux.HandleFunc("/leaderboards/global", api.GlobalLeaderboardHandler(challengeService))
+	mux.HandleFunc("/challenges", api.ChallengeListHandler(challengeService, jwtManager))
+	mux.HandleFunc("/challenges/presence", api.ChallengePresenceHandler(challengeService, jwtManager, cfg.AdminToken))
+
+	// Admin endpoints, disabled unless ADMINTOKEN is set
+	mux.HandleFunc("/admin/outbox", api.OutboxHandler(challengeService, cfg.AdminToken))
+	mux.HandleFunc("/admin/outbox/retry", api.OutboxRetryHandler(challengeService, cfg.AdminToken))
+	mux.HandleFunc("/admin/vars", api.MetricsHandler(cfg.AdminToken))
 
 	// Create HTTP server
 	server := &http.Server{
-		Addr: "0.0.0.0:7777",
+		Addr:    "0.0.0.0:7777",
+		Handler: mux,
 	}
 
 	// Setup graceful shutdown
@@ -152,6 +300,10 @@ func main() {
 			log.Printf("Server shutdown error: %v", err)
 		}
 
+		if err := challengeBroadcaster.Close(); err != nil {
+			log.Printf("Broadcaster shutdown error: %v", err)
+		}
+
 		log.Println("Application shutdown complete")
 		os.Exit(0)
 	}()
@@ -0,0 +1,33 @@
+// Command wsschema writes the WebSocket protocol JSON Schema generated from the Go
+// message types. Run it with go generate ./internal/wss/types after changing them.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/schema"
+	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
+)
+
+func main() {
+	dir := flag.String("dir", "docs", "directory to write the schema to")
+	flag.Parse()
+
+	data, err := schema.Generate()
+	if err != nil {
+		log.Fatalf("generate schema: %v", err)
+	}
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		log.Fatalf("create %s: %v", *dir, err)
+	}
+
+	path := filepath.Join(*dir, schema.FileName(wsstypes.ProtocolVersion))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Fatalf("write %s: %v", path, err)
+	}
+	log.Printf("wrote %s", path)
+}
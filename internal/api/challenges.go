@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/jwt"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/repo"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/service"
+)
+
+// ChallengeListHandler lists active challenges newest first, filtered by any of status,
+// creator and visibility, with cursor pagination. Only public challenges are listed unless
+// visibility=private is asked for with "Authorization: Bearer <challenge token>", which
+// lists the caller's own private challenges.
+// GET /challenges?status=<status>&creatorId=<id>&visibility=<public|private>&cursor=<c>&limit=<n>
+func ChallengeListHandler(svc *service.ChallengeService, jwtManager *jwt.JWTManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Only GET is supported")
+			return
+		}
+
+		query := r.URL.Query()
+		filter := repo.ChallengeFilter{
+			Status:    query.Get("status"),
+			CreatorID: query.Get("creatorId"),
+		}
+		switch query.Get("visibility") {
+		case "", "public":
+			private := false
+			filter.IsPrivate = &private
+		case "private":
+			token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			claims, err := jwtManager.ValidateToken(token)
+			if token == "" || err != nil {
+				writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Listing private challenges requires a valid bearer token")
+				return
+			}
+			if filter.CreatorID != "" && filter.CreatorID != claims.UserID {
+				writeError(w, http.StatusForbidden, "FORBIDDEN", "Only your own private challenges can be listed")
+				return
+			}
+			private := true
+			filter.IsPrivate = &private
+			filter.CreatorID = claims.UserID
+		default:
+			writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "visibility must be public or private")
+			return
+		}
+
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 {
+			limit = 20
+		}
+		if limit > 100 {
+			limit = 100
+		}
+
+		challenges, nextCursor, total, err := svc.ListChallenges(r.Context(), filter, query.Get("cursor"), limit)
+		if errors.Is(err, repo.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "Invalid cursor")
+			return
+		}
+		if err != nil {
+			log.Printf("[API] failed to list challenges: %v", err)
+			writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to list challenges")
+			return
+		}
+
+		summaries := make([]model.ChallengeSummary, len(challenges))
+		for i := range challenges {
+			summaries[i] = challenges[i].Summary()
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"challenges": summaries,
+			"nextCursor": nextCursor,
+			"totalCount": total,
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/service"
+)
+
+// GlobalLeaderboardHandler serves the all-time or a monthly season leaderboard.
+// GET /leaderboards/global?season=<all-time|YYYY-MM>&page=<n>&pageSize=<n>
+func GlobalLeaderboardHandler(svc *service.ChallengeService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Only GET is supported")
+			return
+		}
+
+		season := r.URL.Query().Get("season")
+		page, pageSize := pagination(r)
+
+		entries, total, err := svc.GetGlobalLeaderboard(r.Context(), season, page, pageSize)
+		if errors.Is(err, service.ErrInvalidSeason) {
+			writeError(w, http.StatusBadRequest, "INVALID_REQUEST", err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("[API] failed to get global leaderboard for season %q: %v", season, err)
+			writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get global leaderboard")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"season":      season,
+			"leaderboard": entries,
+			"page":        page,
+			"pageSize":    pageSize,
+			"totalCount":  total,
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// MetricsHandler serves the process's expvar counters, such as dropped and evicted
+// WebSocket messages. Requires "Authorization: Bearer <admin token>"; the endpoint
+// is disabled when no admin token is configured.
+// GET /admin/vars
+func MetricsHandler(adminToken string) http.HandlerFunc {
+	vars := expvar.Handler()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r, adminToken) {
+			return
+		}
+		vars.ServeHTTP(w, r)
+	}
+}
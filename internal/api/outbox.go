@@ -0,0 +1,85 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/service"
+)
+
+// OutboxHandler lists pending and dead-lettered persistence jobs, to spot challenges
+// stuck on their way to MongoDB. Requires "Authorization: Bearer <admin token>"; the
+// endpoint is disabled when no admin token is configured.
+// GET /admin/outbox
+func OutboxHandler(svc *service.ChallengeService, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r, adminToken) {
+			return
+		}
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Only GET is supported")
+			return
+		}
+
+		pending, dead, err := svc.ListPersistJobs(r.Context())
+		if err != nil {
+			log.Printf("[API] failed to list persistence jobs: %v", err)
+			writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to list persistence jobs")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"pending":      pending,
+			"dead":         dead,
+			"pendingCount": len(pending),
+			"deadCount":    len(dead),
+		})
+	}
+}
+
+// OutboxRetryHandler requeues a challenge's persistence job, typically a dead-lettered
+// one, and runs it immediately. Authorized like OutboxHandler.
+// POST /admin/outbox/retry?challengeId=<id>
+func OutboxRetryHandler(svc *service.ChallengeService, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeAdmin(w, r, adminToken) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Only POST is supported")
+			return
+		}
+
+		challengeID := r.URL.Query().Get("challengeId")
+		if challengeID == "" {
+			writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "challengeId is required")
+			return
+		}
+
+		if err := svc.RetryPersistJob(r.Context(), challengeID); err != nil {
+			log.Printf("[API] retry of persistence job %s failed: %v", challengeID, err)
+			writeError(w, http.StatusBadGateway, "PERSIST_FAILED", err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"challengeId": challengeID,
+		})
+	}
+}
+
+// authorizeAdmin checks the bearer admin token, writing the error response if it is wrong
+func authorizeAdmin(w http.ResponseWriter, r *http.Request, adminToken string) bool {
+	if adminToken == "" {
+		writeError(w, http.StatusServiceUnavailable, "UNAVAILABLE", "Admin endpoints are disabled")
+		return false
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Invalid admin token")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/jwt"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/service"
+)
+
+// ChallengePresenceHandler lists the users connected to a challenge on any node. Requires
+// "Authorization: Bearer <token>" with either a participant's challenge token for that
+// challenge or the admin token.
+// GET /challenges/presence?challengeId=<id>
+func ChallengePresenceHandler(svc *service.ChallengeService, jwtManager *jwt.JWTManager, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Only GET is supported")
+			return
+		}
+
+		challengeID := r.URL.Query().Get("challengeId")
+		if challengeID == "" {
+			writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "challengeId is required")
+			return
+		}
+
+		if !authorizeParticipant(w, r, svc, jwtManager, adminToken, challengeID) {
+			return
+		}
+
+		users, err := svc.GetChallengePresence(r.Context(), challengeID)
+		if err != nil {
+			log.Printf("[API] failed to get presence for challenge %s: %v", challengeID, err)
+			writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get presence")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"challengeId": challengeID,
+			"users":       users,
+			"onlineCount": len(users),
+		})
+	}
+}
+
+// authorizeParticipant checks the bearer token is the admin token or a challenge token
+// of a participant of challengeID, writing the error response if it is not
+func authorizeParticipant(w http.ResponseWriter, r *http.Request, svc *service.ChallengeService, jwtManager *jwt.JWTManager, adminToken, challengeID string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "A bearer token is required")
+		return false
+	}
+	if adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+		return true
+	}
+
+	claims, err := jwtManager.ValidateToken(token)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "Invalid or expired token")
+		return false
+	}
+	if claims.ChallengeID != challengeID {
+		writeError(w, http.StatusForbidden, "FORBIDDEN", "Token is not valid for this challenge")
+		return false
+	}
+
+	joined, err := svc.IsParticipant(r.Context(), challengeID, claims.UserID)
+	if err != nil {
+		log.Printf("[API] failed to check participant %s of challenge %s: %v", claims.UserID, challengeID, err)
+		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to check participant")
+		return false
+	}
+	if !joined {
+		writeError(w, http.StatusForbidden, "FORBIDDEN", "User is not a participant in this challenge")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/service"
+)
+
+// RatingHistoryHandler serves a user's current rating and rating history.
+// GET /ratings/history?userId=<id>&page=<n>&pageSize=<n>
+func RatingHistoryHandler(svc *service.ChallengeService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Only GET is supported")
+			return
+		}
+
+		userID := r.URL.Query().Get("userId")
+		if userID == "" {
+			writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "userId is required")
+			return
+		}
+
+		page, pageSize := pagination(r)
+
+		userRating, err := svc.GetUserRating(r.Context(), userID)
+		if err != nil {
+			log.Printf("[API] failed to get rating for user %s: %v", userID, err)
+			writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get rating")
+			return
+		}
+
+		history, err := svc.GetRatingHistory(r.Context(), userID, page, pageSize)
+		if err != nil {
+			log.Printf("[API] failed to get rating history for user %s: %v", userID, err)
+			writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get rating history")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"rating":   userRating,
+			"history":  history,
+			"page":     page,
+			"pageSize": pageSize,
+		})
+	}
+}
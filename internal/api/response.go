@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+)
+
+// writeJSON writes a GenericResponse with the given status code
+func writeJSON(w http.ResponseWriter, status int, payload map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	resp := model.GenericResponse{
+		Success: true,
+		Status:  status,
+		Payload: payload,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("[API] failed to write response: %v", err)
+	}
+}
+
+// writeError writes a failed GenericResponse with the given status code
+func writeError(w http.ResponseWriter, status int, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	resp := model.GenericResponse{
+		Success: false,
+		Status:  status,
+		Error: &model.ErrorInfo{
+			ErrorType: errorType,
+			Code:      status,
+			Message:   message,
+		},
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("[API] failed to write error response: %v", err)
+	}
+}
+
+// pagination reads page and pageSize query params, defaulting to page 1 of 20
+func pagination(r *http.Request) (int, int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if err != nil || pageSize < 1 {
+		pageSize = 20
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+
+	return page, pageSize
+}
@@ -0,0 +1,114 @@
+// Package broadcaster fans challenge broadcasts out to every connection in a challenge,
+// whichever node the connection is on. The local backend serves single-node deployments;
+// the Redis backend relays messages over Pub/Sub so each replica delivers to its own sockets.
+package broadcaster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/client"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/codec"
+	"github.com/redis/go-redis/v9"
+)
+
+// Message is an encoded challenge broadcast. Data is the JSON StandardBroadcastMessage;
+// each connection transcodes it with its negotiated codec.
+type Message struct {
+	ChallengeID string          `json:"challengeId"`
+	Type        string          `json:"type"`
+	UserID      string          `json:"userId,omitempty"`   // deliver only to this user's connections
+	Coalesce    bool            `json:"coalesce,omitempty"` // newer messages of the same type supersede queued ones
+	Data        json.RawMessage `json:"data"`
+}
+
+// Broadcaster delivers messages to the connections of a challenge
+type Broadcaster interface {
+	// Publish delivers msg to every connection in msg.ChallengeID, on any node
+	Publish(ctx context.Context, msg Message) error
+	// Subscribe starts delivering the challenge's messages to this node's connections and
+	// returns once messages published afterwards are sure to arrive
+	Subscribe(challengeID string) error
+	// Unsubscribe stops delivering the challenge's messages to this node
+	Unsubscribe(challengeID string)
+	Close() error
+}
+
+// ClientSource looks up this node's connections for a challenge
+type ClientSource interface {
+	GetAllWSClients(challengeID string) map[string]*client.Client
+	GetUserWSClients(challengeID, userID string) []*client.Client
+}
+
+// Deliver queues a JSON message on every client, keyed by connection ID. The message is
+// encoded once per codec in use rather than once per client; coalesce marks it as a
+// snapshot that supersedes a queued message of the same type.
+func Deliver(wsClients map[string]*client.Client, msgType string, data []byte, coalesce bool) {
+	encoded := make(map[codec.Codec][]byte)
+	for connID, c := range wsClients {
+		if c == nil {
+			continue // Skip nil clients
+		}
+
+		cd := c.Codec()
+		wire, ok := encoded[cd]
+		if !ok {
+			var err error
+			if wire, err = cd.FromJSON(data); err != nil {
+				log.Printf("[Broadcast] failed to encode %s as %s: %v", msgType, cd.Name(), err)
+				continue
+			}
+			encoded[cd] = wire
+		}
+
+		var err error
+		if coalesce {
+			err = c.SendCoalescibleEncoded(msgType, wire)
+		} else {
+			err = c.SendEncoded(wire)
+		}
+		if err != nil {
+			log.Printf("[Broadcast] failed to queue %s for connection %s: %v", msgType, connID, err)
+		}
+	}
+}
+
+// deliverLocal delivers msg to this node's connections for its challenge
+func deliverLocal(clients ClientSource, msg Message) {
+	var wsClients map[string]*client.Client
+	if msg.UserID != "" {
+		userClients := clients.GetUserWSClients(msg.ChallengeID, msg.UserID)
+		wsClients = make(map[string]*client.Client, len(userClients))
+		for _, c := range userClients {
+			wsClients[c.ID()] = c
+		}
+	} else {
+		wsClients = clients.GetAllWSClients(msg.ChallengeID)
+	}
+
+	Deliver(wsClients, msg.Type, msg.Data, msg.Coalesce)
+}
+
+// Backend names accepted by New
+const (
+	BackendLocal = "local"
+	BackendRedis = "redis"
+)
+
+// New creates the broadcaster for a backend name; an empty name means local
+func New(backend string, rdb *redis.Client, clients ClientSource) (Broadcaster, error) {
+	switch backend {
+	case "", BackendLocal:
+		return NewLocal(clients), nil
+	case BackendRedis:
+		if rdb == nil {
+			return nil, errors.New("redis broadcaster requires a redis client")
+		}
+		return NewRedis(rdb, clients), nil
+	default:
+		return nil, fmt.Errorf("unknown broadcast backend %q", backend)
+	}
+}
@@ -0,0 +1,30 @@
+package broadcaster
+
+import "context"
+
+// Local delivers messages to this node's connections only, for single-node deployments
+type Local struct {
+	clients ClientSource
+}
+
+// NewLocal creates a broadcaster that delivers directly to clients
+func NewLocal(clients ClientSource) *Local {
+	return &Local{clients: clients}
+}
+
+func (b *Local) Publish(_ context.Context, msg Message) error {
+	deliverLocal(b.clients, msg)
+	return nil
+}
+
+// Subscribe is a no-op; every local connection already receives its challenge's messages
+func (b *Local) Subscribe(string) error {
+	return nil
+}
+
+// Unsubscribe is a no-op
+func (b *Local) Unsubscribe(string) {}
+
+func (b *Local) Close() error {
+	return nil
+}
@@ -0,0 +1,186 @@
+package broadcaster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// channelPrefix namespaces broadcast channels; kept apart from the challenge:<id> keys
+const channelPrefix = "challenge_broadcast:"
+
+const (
+	// subscribeTimeout bounds a subscribe or unsubscribe round trip, including the wait
+	// for Redis to confirm the subscription
+	subscribeTimeout = 5 * time.Second
+	// receiveIdle is how long the receive loop waits for a message before pinging Redis,
+	// so a dead connection is noticed and replaced
+	receiveIdle = 30 * time.Second
+	// receiveRetryDelay is the pause after a receive error before go-redis reconnects
+	receiveRetryDelay = time.Second
+)
+
+// Redis relays messages through Redis Pub/Sub. Each node subscribes to the channels of
+// the challenges it has connections for and delivers what it receives to them, including
+// its own publishes, so every replica sees the same order per channel.
+type Redis struct {
+	client  *redis.Client
+	clients ClientSource
+	pubsub  *redis.PubSub
+	done    chan struct{}
+
+	mu      sync.Mutex
+	pending map[string][]chan struct{} // channel -> waiters for its subscribe confirmation
+}
+
+// NewRedis creates a Pub/Sub broadcaster and starts its receive loop
+func NewRedis(rdb *redis.Client, clients ClientSource) *Redis {
+	b := &Redis{
+		client:  rdb,
+		clients: clients,
+		pubsub:  rdb.Subscribe(context.Background()),
+		done:    make(chan struct{}),
+		pending: make(map[string][]chan struct{}),
+	}
+
+	go b.receive()
+	return b
+}
+
+func channel(challengeID string) string {
+	return channelPrefix + challengeID
+}
+
+// Publish sends msg to every subscribed node. If Redis is unreachable the message is
+// still delivered to this node's connections, so a single node keeps working.
+func (b *Redis) Publish(ctx context.Context, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal broadcast: %w", err)
+	}
+
+	if err := b.client.Publish(ctx, channel(msg.ChallengeID), data).Err(); err != nil {
+		deliverLocal(b.clients, msg)
+		return fmt.Errorf("failed to publish to challenge %s, delivered locally only: %w", msg.ChallengeID, err)
+	}
+	return nil
+}
+
+// Subscribe subscribes to the challenge's channel and waits until Redis confirms it, so
+// messages published once it returns reach this node
+func (b *Redis) Subscribe(challengeID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+	defer cancel()
+
+	name := channel(challengeID)
+	confirmed := make(chan struct{})
+	b.mu.Lock()
+	b.pending[name] = append(b.pending[name], confirmed)
+	b.mu.Unlock()
+	defer b.forget(name, confirmed)
+
+	if err := b.pubsub.Subscribe(ctx, name); err != nil {
+		return fmt.Errorf("failed to subscribe to challenge %s: %w", challengeID, err)
+	}
+
+	select {
+	case <-confirmed:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("subscription to challenge %s not confirmed: %w", challengeID, ctx.Err())
+	}
+}
+
+func (b *Redis) Unsubscribe(challengeID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+	defer cancel()
+
+	if err := b.pubsub.Unsubscribe(ctx, channel(challengeID)); err != nil {
+		log.Printf("[Broadcaster] failed to unsubscribe from challenge %s: %v", challengeID, err)
+	}
+}
+
+// confirm wakes the Subscribe calls waiting on the channel
+func (b *Redis) confirm(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, waiter := range b.pending[name] {
+		close(waiter)
+	}
+	delete(b.pending, name)
+}
+
+// forget drops a waiter that gave up or was already woken
+func (b *Redis) forget(name string, waiter chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	waiters := b.pending[name]
+	for i, w := range waiters {
+		if w == waiter {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(b.pending, name)
+	} else {
+		b.pending[name] = waiters
+	}
+}
+
+// Close stops the receive loop and closes the subscription
+func (b *Redis) Close() error {
+	err := b.pubsub.Close()
+	<-b.done
+	return err
+}
+
+// receive delivers messages from subscribed channels and confirms subscriptions until the
+// subscription is closed; go-redis reconnects and resubscribes on its own after connection
+// errors, and an idle connection is pinged so a dead one is noticed
+func (b *Redis) receive() {
+	defer close(b.done)
+
+	ctx := context.Background()
+	for {
+		received, err := b.pubsub.ReceiveTimeout(ctx, receiveIdle)
+		if err != nil {
+			if errors.Is(err, redis.ErrClosed) {
+				return
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if err := b.pubsub.Ping(ctx); err != nil {
+					log.Printf("[Broadcaster] ping failed: %v", err)
+				}
+				continue
+			}
+			log.Printf("[Broadcaster] receive failed: %v", err)
+			time.Sleep(receiveRetryDelay)
+			continue
+		}
+
+		switch m := received.(type) {
+		case *redis.Subscription:
+			if m.Kind == "subscribe" {
+				b.confirm(m.Channel)
+			}
+		case *redis.Message:
+			var msg Message
+			if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+				log.Printf("[Broadcaster] invalid message on %s: %v", m.Channel, err)
+				continue
+			}
+			deliverLocal(b.clients, msg)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -21,6 +22,48 @@ type Config struct {
 	JWTSecret string
 
 	APIGatewayTokenCheckURL string
+
+	MatchmakingUseRating bool
+
+	ProblemsGRPCURL string
+
+	WSSendQueueSize       int
+	WSWriteTimeoutSeconds int
+	WSMaxLagSeconds       int
+	WSPingIntervalSeconds int
+	WSPongWaitSeconds     int
+	WSMaxMessageBytes     int64
+
+	WSAllowedOrigins []string
+	WSOriginDevMode  bool
+
+	WSResumeMaxEvents int
+
+	WSCompressionEnabled   bool
+	WSCompressionLevel     int
+	WSCompressionThreshold int
+
+	WSKickOlderSessions bool
+
+	BroadcastBackend string
+
+	NodeID             string
+	PresenceTTLSeconds int
+
+	RedisTxMaxAttempts int
+
+	ChallengeExpiryListener     bool
+	ChallengeExpiryGraceMinutes int
+
+	OutboxMaxAttempts        int
+	OutboxBaseBackoffSeconds int
+	OutboxMaxBackoffSeconds  int
+
+	AdminToken string
+
+	MongoWriteConcern        string
+	MongoWriteJournal        bool
+	MongoWriteTimeoutSeconds int
 }
 
 func LoadConfig() Config {
@@ -39,6 +82,39 @@ func LoadConfig() Config {
 		RedisDB:                 getEnvInt("REDISDB", 0),
 		APIGatewayTokenCheckURL: getEnv("APIGATEWAYTOKENCHECKURL", "http://localhost:7000/api/v1/users/check-token"),
 		JWTSecret:getEnv("JWTSECRET","secrettt"),
+		MatchmakingUseRating:    getEnvBool("MATCHMAKINGUSERATING", true),
+		WSSendQueueSize:         getEnvInt("WSSENDQUEUESIZE", 256),
+		WSWriteTimeoutSeconds:   getEnvInt("WSWRITETIMEOUTSECONDS", 10),
+		WSMaxLagSeconds:         getEnvInt("WSMAXLAGSECONDS", 30),
+		WSPingIntervalSeconds:   getEnvInt("WSPINGINTERVALSECONDS", 54),
+		WSPongWaitSeconds:       getEnvInt("WSPONGWAITSECONDS", 60),
+		WSMaxMessageBytes:       int64(getEnvInt("WSMAXMESSAGEBYTES", 64*1024)),
+		WSAllowedOrigins:        getEnvList("WSALLOWEDORIGINS", nil),
+		WSOriginDevMode:         getEnvBool("WSORIGINDEVMODE", false),
+		WSResumeMaxEvents:       getEnvInt("WSRESUMEMAXEVENTS", 1000),
+		WSCompressionEnabled:    getEnvBool("WSCOMPRESSIONENABLED", true),
+		WSCompressionLevel:      getEnvInt("WSCOMPRESSIONLEVEL", 1),
+		WSCompressionThreshold:  getEnvInt("WSCOMPRESSIONTHRESHOLDBYTES", 1024),
+		WSKickOlderSessions:     getEnvBool("WSKICKOLDERSESSIONS", false),
+		BroadcastBackend:        getEnv("BROADCASTBACKEND", "local"),
+		NodeID:                  getEnv("NODEID", ""),
+		PresenceTTLSeconds:      getEnvInt("PRESENCETTLSECONDS", 30),
+		RedisTxMaxAttempts:      getEnvInt("REDISTXMAXATTEMPTS", 10),
+
+		ChallengeExpiryListener:     getEnvBool("CHALLENGEEXPIRYLISTENER", true),
+		ChallengeExpiryGraceMinutes: getEnvInt("CHALLENGEEXPIRYGRACEMINUTES", 60),
+
+		OutboxMaxAttempts:        getEnvInt("OUTBOXMAXATTEMPTS", 10),
+		OutboxBaseBackoffSeconds: getEnvInt("OUTBOXBASEBACKOFFSECONDS", 1),
+		OutboxMaxBackoffSeconds:  getEnvInt("OUTBOXMAXBACKOFFSECONDS", 300),
+
+		AdminToken: getEnv("ADMINTOKEN", ""),
+
+		MongoWriteConcern:        getEnv("MONGOWRITECONCERN", "majority"),
+		MongoWriteJournal:        getEnvBool("MONGOWRITEJOURNAL", false),
+		MongoWriteTimeoutSeconds: getEnvInt("MONGOWRITETIMEOUTSECONDS", 10),
+
+		ProblemsGRPCURL: getEnv("PROBLEMSGRPCURL", "localhost:50055"),
 	}
 
 	return config
@@ -51,6 +127,31 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvList reads a comma-separated list, skipping empty items
+func getEnvList(key string, defaultValue []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnvInt(key string, defaultValue int) int {
 	if value, exists := os.LookupEnv(key); exists {
 		if intValue, err := strconv.Atoi(value); err == nil {
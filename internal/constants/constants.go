@@ -26,8 +26,18 @@ const (
 	LEADERBOARD_UPDATE   = "LEADERBOARD_UPDATE"
 	NEW_SUBMISSION       = "NEW_SUBMISSION"
 	CURRENT_LEADERBOARD  = "CURRENT_LEADERBOARD"
+	RATING_UPDATE        = "RATING_UPDATE"
+	QUEUE_MATCH          = "QUEUE_MATCH"
+	MATCH_FOUND          = "MATCH_FOUND"
+	REMATCH_REQUEST      = "REMATCH_REQUEST"
+	REMATCH_ACCEPT       = "REMATCH_ACCEPT"
+	RESUME               = "RESUME"
+	PRESENCE             = "PRESENCE"
+	ACK                  = "ACK"
+	ERROR                = "ERROR"
 )
 
 const (
-	BufferTime = 10 * time.Minute
+	BufferTime     = 10 * time.Minute
+	MatchTimeLimit = 30 * time.Minute
 )
@@ -1,9 +1,15 @@
 package global
 
 import (
+	"github.com/lijuuu/ChallengeWssManagerService/internal/broadcaster"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/jwt"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/leaderboard"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/matchmaking"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/outbox"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/presence"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/problems"
 	localstate "github.com/lijuuu/ChallengeWssManagerService/internal/local"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/rematch"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/repo"
 )
 
@@ -12,6 +18,12 @@ type State struct {
 	Redis              *repo.RedisRepository
 	Mongo              *repo.MongoRepository
 	LocalState         *localstate.LocalStateManager
+	Broadcaster        broadcaster.Broadcaster
+	Presence           *presence.Registry
 	LeaderboardManager *leaderboard.LeaderboardManager
 	JwtManager         *jwt.JWTManager
+	Matchmaker         *matchmaking.Matchmaker
+	Rematches          *rematch.Manager
+	Outbox             *outbox.Worker
+	Problems           *problems.Selector
 }
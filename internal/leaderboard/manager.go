@@ -1,11 +1,15 @@
 package leaderboard
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
 	redisboard "github.com/lijuuu/RedisBoard"
+	"github.com/redis/go-redis/v9"
 )
 
 // ParticipantLeaderboardData holds user rank information
@@ -21,6 +25,8 @@ type LeaderboardManager struct {
 	Boards map[string]*redisboard.Leaderboard // challengeID -> RedisBoard instance
 	Config *redisboard.Config
 	MU     sync.RWMutex
+
+	rdb *redis.Client // deletes board keys of challenges not open on this node
 }
 
 // NewLeaderboardManager creates a new LeaderboardManager instance
@@ -37,6 +43,7 @@ func NewLeaderboardManager(redisAddr, redisPassword string) *LeaderboardManager
 	return &LeaderboardManager{
 		Boards: make(map[string]*redisboard.Leaderboard),
 		Config: config,
+		rdb:    redis.NewClient(&redis.Options{Addr: redisAddr, Password: redisPassword}),
 	}
 }
 
@@ -52,7 +59,7 @@ func (lm *LeaderboardManager) InitializeLeaderboard(challengeID string) error {
 
 	// Create challenge-specific config
 	config := *lm.Config
-	config.Namespace = fmt.Sprintf("challenge_%s", challengeID)
+	config.Namespace = namespace(challengeID)
 
 	// Create RedisBoard instance
 	board, err := redisboard.New(config)
@@ -85,6 +92,27 @@ func (lm *LeaderboardManager) CleanupLeaderboard(challengeID string) error {
 	return nil
 }
 
+// DeleteLeaderboard closes a challenge's RedisBoard instance and deletes its keys. It
+// works on any node, whether or not the board was initialized there.
+func (lm *LeaderboardManager) DeleteLeaderboard(ctx context.Context, challengeID string) error {
+	if err := lm.CleanupLeaderboard(challengeID); err != nil {
+		return err
+	}
+
+	// RedisBoard keeps a global ranking, a user-to-entity map and a ranking per entity;
+	// challenges only use the empty entity
+	ns := namespace(challengeID)
+	if err := lm.rdb.Del(ctx, ns+":global", ns+":user:entities", ns+":entity:").Err(); err != nil {
+		return fmt.Errorf("failed to delete leaderboard keys for challenge %s: %w", challengeID, err)
+	}
+	return nil
+}
+
+// namespace is the RedisBoard key prefix of a challenge's leaderboard
+func namespace(challengeID string) string {
+	return fmt.Sprintf("challenge_%s", challengeID)
+}
+
 // getBoard safely retrieves a RedisBoard instance for a challenge
 func (lm *LeaderboardManager) getBoard(challengeID string) (*redisboard.Leaderboard, error) {
 	lm.MU.RLock()
@@ -146,48 +174,20 @@ func (lm *LeaderboardManager) GetLeaderboard(challengeID string, limit int, chal
 		// Calculate problems completed for this user using the challenge document
 		problemsCompleted := lm.CalculateProblemsCompleted(challengeDoc, user.ID)
 
+		totalTimeTaken, lastSubmissionAt := lm.calculateTiebreakData(challengeDoc, user.ID)
+
 		entry := &model.LeaderboardEntry{
 			UserID:            user.ID,
 			ProblemsCompleted: problemsCompleted,
 			TotalScore:        int(user.Score),
+			TotalTimeTaken:    totalTimeTaken,
+			LastSubmissionAt:  lastSubmissionAt,
 			Rank:              0, // Will be calculated after sorting
 		}
 		leaderboard = append(leaderboard, entry)
 	}
 
-	// Sort leaderboard considering both score and problems completed
-	// Primary sort: Total Score (descending)
-	// Secondary sort: Problems Completed (descending)
-	// Tertiary sort: UserID (ascending) for consistent ordering
-	for i := 0; i < len(leaderboard); i++ {
-		for j := i + 1; j < len(leaderboard); j++ {
-			shouldSwap := false
-
-			// Primary comparison: Total Score (higher is better)
-			if leaderboard[i].TotalScore < leaderboard[j].TotalScore {
-				shouldSwap = true
-			} else if leaderboard[i].TotalScore == leaderboard[j].TotalScore {
-				// Secondary comparison: Problems Completed (higher is better)
-				if leaderboard[i].ProblemsCompleted < leaderboard[j].ProblemsCompleted {
-					shouldSwap = true
-				} else if leaderboard[i].ProblemsCompleted == leaderboard[j].ProblemsCompleted {
-					// Tertiary comparison: UserID (lexicographic for consistency)
-					if leaderboard[i].UserID > leaderboard[j].UserID {
-						shouldSwap = true
-					}
-				}
-			}
-
-			if shouldSwap {
-				leaderboard[i], leaderboard[j] = leaderboard[j], leaderboard[i]
-			}
-		}
-	}
-
-	// Assign ranks after sorting
-	for i := range leaderboard {
-		leaderboard[i].Rank = i + 1 // 1-based ranking
-	}
+	rankEntries(leaderboard)
 
 	// Apply limit if specified
 	if limit > 0 && len(leaderboard) > limit {
@@ -269,3 +269,99 @@ func (lm *LeaderboardManager) CalculateProblemsCompleted(challengeDoc *model.Cha
 
 	return problemsCompleted
 }
+
+// GetFinalLeaderboard builds the complete final standings for a challenge.
+// Scores come from RedisBoard when the board is still open, falling back to the
+// participant totals stored in the challenge document. Unlike GetLeaderboard it is
+// not capped to RedisBoard's top K, so every participant gets a ranked entry.
+func (lm *LeaderboardManager) GetFinalLeaderboard(challengeID string, challengeDoc *model.ChallengeDocument) []*model.LeaderboardEntry {
+	if challengeDoc == nil {
+		return []*model.LeaderboardEntry{}
+	}
+
+	// Collect board scores, if the board is still available
+	boardScores := make(map[string]int)
+	if board, err := lm.getBoard(challengeID); err == nil {
+		if users, err := board.GetTopKGlobal(); err == nil {
+			for _, user := range users {
+				boardScores[user.ID] = int(user.Score)
+			}
+		}
+	}
+
+	leaderboard := make([]*model.LeaderboardEntry, 0, len(challengeDoc.Participants))
+	for userID, participant := range challengeDoc.Participants {
+		totalScore := 0
+		if participant != nil {
+			totalScore = participant.TotalScore
+		}
+		if score, exists := boardScores[userID]; exists {
+			totalScore = score
+		}
+
+		totalTimeTaken, lastSubmissionAt := lm.calculateTiebreakData(challengeDoc, userID)
+
+		leaderboard = append(leaderboard, &model.LeaderboardEntry{
+			UserID:            userID,
+			ProblemsCompleted: lm.CalculateProblemsCompleted(challengeDoc, userID),
+			TotalScore:        totalScore,
+			TotalTimeTaken:    totalTimeTaken,
+			LastSubmissionAt:  lastSubmissionAt,
+		})
+	}
+
+	rankEntries(leaderboard)
+	return leaderboard
+}
+
+// calculateTiebreakData returns the total time (ms) a participant spent on solved
+// problems and the unix time of their last successful submission.
+func (lm *LeaderboardManager) calculateTiebreakData(challengeDoc *model.ChallengeDocument, userID string) (int64, int64) {
+	if challengeDoc == nil || challengeDoc.Participants == nil {
+		return 0, 0
+	}
+
+	participant, exists := challengeDoc.Participants[userID]
+	if !exists || participant == nil {
+		return 0, 0
+	}
+
+	var totalTimeTaken time.Duration
+	var lastSubmissionAt int64
+	for _, problemMeta := range participant.ProblemsDone {
+		if problemMeta.Score <= 0 {
+			continue
+		}
+		totalTimeTaken += time.Duration(problemMeta.TimeTaken)
+		if problemMeta.CompletedAt > lastSubmissionAt {
+			lastSubmissionAt = problemMeta.CompletedAt
+		}
+	}
+
+	return totalTimeTaken.Milliseconds(), lastSubmissionAt
+}
+
+// rankEntries sorts leaderboard entries and assigns 1-based ranks.
+// Primary sort: Total Score (descending)
+// Secondary sort: Problems Completed (descending)
+// Tertiary sort: Total Time Taken (ascending)
+// Final sort: UserID (ascending) for consistent ordering
+func rankEntries(leaderboard []*model.LeaderboardEntry) {
+	sort.SliceStable(leaderboard, func(i, j int) bool {
+		a, b := leaderboard[i], leaderboard[j]
+		if a.TotalScore != b.TotalScore {
+			return a.TotalScore > b.TotalScore
+		}
+		if a.ProblemsCompleted != b.ProblemsCompleted {
+			return a.ProblemsCompleted > b.ProblemsCompleted
+		}
+		if a.TotalTimeTaken != b.TotalTimeTaken {
+			return a.TotalTimeTaken < b.TotalTimeTaken
+		}
+		return a.UserID < b.UserID
+	})
+
+	for i := range leaderboard {
+		leaderboard[i].Rank = i + 1 // 1-based ranking
+	}
+}
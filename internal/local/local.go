@@ -1,23 +1,38 @@
 package state
 
 import (
+	"log"
 	"sync"
 
-	"github.com/gorilla/websocket"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/client"
 )
 
 
+// Subscriber is told when a challenge gains its first connection on this node and when it
+// loses its last, so cross-node broadcasts are only received where they can be delivered.
+// It is called without the challenge's lock held, since it may do network I/O; Subscribe
+// returns once the subscription is confirmed.
+type Subscriber interface {
+	Subscribe(challengeID string) error
+	Unsubscribe(challengeID string)
+}
+
 type LocalStateManager struct {
-	challengeStates map[string]*ChallengeLocalState
-	mu              sync.RWMutex
+	challengeStates   map[string]*ChallengeLocalState
+	kickOlderSessions bool
+	subscriber        Subscriber
+	mu                sync.RWMutex
 }
 
 type ChallengeLocalState struct {
 	Sessions  map[string]*model.Session
-	WSClients map[string]*websocket.Conn
+	WSClients map[string]map[string]*client.Client // userID -> connection ID -> client
 	MU        sync.RWMutex
 	EventChan chan model.Event
+
+	subMU      sync.Mutex // serializes subscriber calls for the challenge
+	subscribed bool       // guarded by subMU
 }
 
 func NewLocalStateManager() *LocalStateManager {
@@ -26,6 +41,23 @@ func NewLocalStateManager() *LocalStateManager {
 	}
 }
 
+// SetKickOlderSessions sets whether a user's new connection to a challenge closes their
+// older ones, allowing one session per user instead of one per tab or device
+func (lsm *LocalStateManager) SetKickOlderSessions(kick bool) {
+	lsm.mu.Lock()
+	defer lsm.mu.Unlock()
+
+	lsm.kickOlderSessions = kick
+}
+
+// SetSubscriber sets the subscriber notified as challenges gain and lose connections
+func (lsm *LocalStateManager) SetSubscriber(s Subscriber) {
+	lsm.mu.Lock()
+	defer lsm.mu.Unlock()
+
+	lsm.subscriber = s
+}
+
 // GetChallengeState returns the local state for a challenge, creating it if it doesn't exist
 func (lsm *LocalStateManager) GetChallengeState(challengeID string) *ChallengeLocalState {
 	lsm.mu.Lock()
@@ -35,7 +67,7 @@ func (lsm *LocalStateManager) GetChallengeState(challengeID string) *ChallengeLo
 	if !exists {
 		state = &ChallengeLocalState{
 			Sessions:  make(map[string]*model.Session),
-			WSClients: make(map[string]*websocket.Conn),
+			WSClients: make(map[string]map[string]*client.Client),
 			EventChan: make(chan model.Event, 100),
 		}
 		lsm.challengeStates[challengeID] = state
@@ -86,73 +118,211 @@ func (lsm *LocalStateManager) GetSession(challengeID, userID string) (*model.Ses
 	return session, found
 }
 
-// AddWSClient adds a WebSocket client to the challenge's local state
-func (lsm *LocalStateManager) AddWSClient(challengeID, userID string, conn *websocket.Conn) {
+// AddWSClient registers one of a user's WebSocket connections for the challenge. A user
+// may hold several connections (tabs or devices); each receives every broadcast. When
+// older sessions are kicked, the user's existing connections are removed and closed with
+// CloseSessionReplaced. first reports whether the user had no connection before, so
+// presence changes are announced once per user rather than once per connection.
+func (lsm *LocalStateManager) AddWSClient(challengeID, userID string, c *client.Client) (first bool) {
+	lsm.mu.RLock()
+	kickOlder := lsm.kickOlderSessions
+	subscriber := lsm.subscriber
+	lsm.mu.RUnlock()
+
 	state := lsm.GetChallengeState(challengeID)
+	defer lsm.syncSubscription(challengeID, state, subscriber)
+
 	state.MU.Lock()
 	defer state.MU.Unlock()
 
-	state.WSClients[userID] = conn
+	conns, exists := state.WSClients[userID]
+	first = !exists || len(conns) == 0
+	if !exists {
+		conns = make(map[string]*client.Client)
+		state.WSClients[userID] = conns
+	}
+
+	if kickOlder {
+		for id, old := range conns {
+			if old == c {
+				continue
+			}
+			log.Printf("[LocalState] replacing connection %s of user %s in challenge %s", id, userID, challengeID)
+			old.CloseWithCode(client.CloseSessionReplaced)
+			delete(conns, id)
+		}
+	}
+
+	conns[c.ID()] = c
+	return first
 }
 
-// RemoveWSClient removes a WebSocket client from the challenge's local state
-func (lsm *LocalStateManager) RemoveWSClient(challengeID, userID string) {
+// RemoveWSClient removes one of a user's WebSocket connections from the challenge without
+// closing it, since the connection may have moved to another challenge; the connection's
+// handler closes it on disconnect. removed is false if the connection was not registered,
+// e.g. it never joined or was replaced; remaining is how many connections the user still
+// has in the challenge.
+func (lsm *LocalStateManager) RemoveWSClient(challengeID, userID string, c *client.Client) (removed bool, remaining int) {
 	lsm.mu.RLock()
 	state, exists := lsm.challengeStates[challengeID]
+	subscriber := lsm.subscriber
 	lsm.mu.RUnlock()
 
 	if !exists {
-		return
+		return false, 0
 	}
+	defer lsm.syncSubscription(challengeID, state, subscriber)
 
 	state.MU.Lock()
 	defer state.MU.Unlock()
 
-	if conn, exists := state.WSClients[userID]; exists {
-		conn.Close()
+	conns := state.WSClients[userID]
+	if registered, ok := conns[c.ID()]; ok && registered == c {
+		delete(conns, c.ID())
+		removed = true
+	}
+	if len(conns) == 0 {
 		delete(state.WSClients, userID)
 	}
+
+	return removed, len(conns)
+}
+
+// syncSubscription subscribes the node to the challenge while it has connections and
+// unsubscribes it once it has none. It runs after the challenge's lock is released so a
+// slow Redis doesn't stall broadcasts and joins, and serializes with itself so a join and
+// a leave racing each other leave the subscription matching the final state. A failed
+// subscribe is retried on the next connection change.
+func (lsm *LocalStateManager) syncSubscription(challengeID string, state *ChallengeLocalState, subscriber Subscriber) {
+	if subscriber == nil {
+		return
+	}
+
+	state.subMU.Lock()
+	defer state.subMU.Unlock()
+
+	state.MU.RLock()
+	connected := len(state.WSClients) > 0
+	state.MU.RUnlock()
+
+	switch {
+	case connected && !state.subscribed:
+		if err := subscriber.Subscribe(challengeID); err != nil {
+			log.Printf("[LocalState] failed to subscribe to challenge %s: %v", challengeID, err)
+			return
+		}
+		state.subscribed = true
+	case !connected && state.subscribed:
+		subscriber.Unsubscribe(challengeID)
+		state.subscribed = false
+	}
 }
 
-// GetWSClient retrieves a WebSocket client from the challenge's local state
-func (lsm *LocalStateManager) GetWSClient(challengeID, userID string) (*websocket.Conn, bool) {
+// GetUserWSClients returns all of a user's WebSocket connections in the challenge
+func (lsm *LocalStateManager) GetUserWSClients(challengeID, userID string) []*client.Client {
 	lsm.mu.RLock()
 	state, exists := lsm.challengeStates[challengeID]
 	lsm.mu.RUnlock()
 
 	if !exists {
-		return nil, false
+		return nil
 	}
 
 	state.MU.RLock()
 	defer state.MU.RUnlock()
 
-	conn, found := state.WSClients[userID]
-	return conn, found
+	clients := make([]*client.Client, 0, len(state.WSClients[userID]))
+	for _, c := range state.WSClients[userID] {
+		clients = append(clients, c)
+	}
+
+	return clients
 }
 
-// GetAllWSClients returns all WebSocket clients for a challenge
-func (lsm *LocalStateManager) GetAllWSClients(challengeID string) map[string]*websocket.Conn {
+// IsUserConnected reports whether the user has at least one connection in the challenge
+func (lsm *LocalStateManager) IsUserConnected(challengeID, userID string) bool {
 	lsm.mu.RLock()
 	state, exists := lsm.challengeStates[challengeID]
 	lsm.mu.RUnlock()
 
 	if !exists {
-		return make(map[string]*websocket.Conn)
+		return false
+	}
+
+	state.MU.RLock()
+	defer state.MU.RUnlock()
+
+	return len(state.WSClients[userID]) > 0
+}
+
+// GetConnectedUserIDs returns the users with at least one connection in the challenge
+func (lsm *LocalStateManager) GetConnectedUserIDs(challengeID string) []string {
+	lsm.mu.RLock()
+	state, exists := lsm.challengeStates[challengeID]
+	lsm.mu.RUnlock()
+
+	if !exists {
+		return nil
+	}
+
+	state.MU.RLock()
+	defer state.MU.RUnlock()
+
+	userIDs := make([]string, 0, len(state.WSClients))
+	for userID, conns := range state.WSClients {
+		if len(conns) > 0 {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	return userIDs
+}
+
+// GetAllWSClients returns every WebSocket connection in a challenge, keyed by connection ID
+func (lsm *LocalStateManager) GetAllWSClients(challengeID string) map[string]*client.Client {
+	lsm.mu.RLock()
+	state, exists := lsm.challengeStates[challengeID]
+	lsm.mu.RUnlock()
+
+	if !exists {
+		return make(map[string]*client.Client)
 	}
 
 	state.MU.RLock()
 	defer state.MU.RUnlock()
 
 	// Return a copy to avoid concurrent access issues
-	clients := make(map[string]*websocket.Conn)
-	for userID, conn := range state.WSClients {
-		clients[userID] = conn
+	clients := make(map[string]*client.Client)
+	for _, conns := range state.WSClients {
+		for connID, c := range conns {
+			clients[connID] = c
+		}
 	}
 
 	return clients
 }
 
+// ForEachWSClient calls fn for every WebSocket connection on this node. fn runs with the
+// challenge's lock held and must not call back into the manager.
+func (lsm *LocalStateManager) ForEachWSClient(fn func(challengeID, userID string, c *client.Client)) {
+	lsm.mu.RLock()
+	states := make(map[string]*ChallengeLocalState, len(lsm.challengeStates))
+	for id, state := range lsm.challengeStates {
+		states[id] = state
+	}
+	lsm.mu.RUnlock()
+
+	for challengeID, state := range states {
+		state.MU.RLock()
+		for userID, conns := range state.WSClients {
+			for _, c := range conns {
+				fn(challengeID, userID, c)
+			}
+		}
+		state.MU.RUnlock()
+	}
+}
+
 // SendEvent sends an event to the challenge's event channel
 func (lsm *LocalStateManager) SendEvent(challengeID string, event model.Event) {
 	state := lsm.GetChallengeState(challengeID)
@@ -174,26 +344,33 @@ func (lsm *LocalStateManager) GetEventChannel(challengeID string) <-chan model.E
 // CleanupChallenge removes all local state for a challenge
 func (lsm *LocalStateManager) CleanupChallenge(challengeID string) {
 	lsm.mu.Lock()
-	defer lsm.mu.Unlock()
-
 	state, exists := lsm.challengeStates[challengeID]
 	if !exists {
+		lsm.mu.Unlock()
 		return
 	}
+	subscriber := lsm.subscriber
 
 	state.MU.Lock()
-	defer state.MU.Unlock()
 
 	// Close all WebSocket connections
-	for _, conn := range state.WSClients {
-		conn.Close()
+	for _, conns := range state.WSClients {
+		for _, c := range conns {
+			c.Close()
+		}
 	}
+	state.WSClients = make(map[string]map[string]*client.Client)
 
 	// Close event channel
 	close(state.EventChan)
 
 	// Remove from map
 	delete(lsm.challengeStates, challengeID)
+
+	state.MU.Unlock()
+	lsm.mu.Unlock()
+
+	lsm.syncSubscription(challengeID, state, subscriber)
 }
 
 // GetAllChallengeIDs returns all challenge IDs that have local state
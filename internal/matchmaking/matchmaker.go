@@ -0,0 +1,253 @@
+package matchmaking
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/client"
+)
+
+const (
+	MinMatchSize     = 2
+	MaxMatchSize     = 10
+	TickInterval     = 1 * time.Second
+	InitialRatingGap = 100.0
+	RatingGapStep    = 50.0 // widened every RatingGapEvery while waiting
+	RatingGapEvery   = 10 * time.Second
+	MaxRatingGap     = 1000.0
+	MaxMatchAttempts = 3 // matches a ticket may fail to be placed into before it is dropped
+)
+
+// Preferences describes the kind of challenge a user wants to be matched into
+type Preferences struct {
+	Size               int `json:"size"`
+	MaxEasyQuestions   int `json:"maxEasyQuestions"`
+	MaxMediumQuestions int `json:"maxMediumQuestions"`
+	MaxHardQuestions   int `json:"maxHardQuestions"`
+}
+
+// Ticket is a queued matchmaking request for a single user
+type Ticket struct {
+	UserID      string
+	Client      *client.Client
+	Preferences Preferences
+	Rating      float64
+	EnqueuedAt  time.Time
+	Attempts    int // matches this ticket failed to be placed into
+}
+
+// Match is a group of compatible tickets ready to be placed into a challenge
+type Match struct {
+	Tickets     []*Ticket
+	Preferences Preferences
+}
+
+// Matchmaker pairs queued users into matches with compatible preferences,
+// optionally restricted to a rating band that widens the longer a user waits
+type Matchmaker struct {
+	tickets   map[string]*Ticket // userID -> ticket
+	useRating bool
+	onMatch   func(*Match)
+	mu        sync.Mutex
+}
+
+// NewMatchmaker creates a new Matchmaker instance
+func NewMatchmaker(useRating bool) *Matchmaker {
+	return &Matchmaker{
+		tickets:   make(map[string]*Ticket),
+		useRating: useRating,
+	}
+}
+
+// SetMatchHandler sets the callback invoked for every match found
+func (m *Matchmaker) SetMatchHandler(onMatch func(*Match)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onMatch = onMatch
+}
+
+// Enqueue adds a ticket to the queue, replacing any ticket the user already has.
+// Returns the number of users currently waiting.
+func (m *Matchmaker) Enqueue(ticket *Ticket) (int, error) {
+	if ticket == nil || ticket.UserID == "" {
+		return 0, errors.New("ticket must have a userID")
+	}
+
+	if ticket.Preferences.Size == 0 {
+		ticket.Preferences.Size = MinMatchSize
+	}
+	if ticket.Preferences.Size < MinMatchSize || ticket.Preferences.Size > MaxMatchSize {
+		return 0, errors.New("match size out of range")
+	}
+	prefs := ticket.Preferences
+	if prefs.MaxEasyQuestions < 0 || prefs.MaxMediumQuestions < 0 || prefs.MaxHardQuestions < 0 ||
+		prefs.MaxEasyQuestions+prefs.MaxMediumQuestions+prefs.MaxHardQuestions == 0 {
+		return 0, errors.New("at least one question is required")
+	}
+	if ticket.EnqueuedAt.IsZero() {
+		ticket.EnqueuedAt = time.Now()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tickets[ticket.UserID] = ticket
+	return len(m.tickets), nil
+}
+
+// Requeue puts the tickets of a match that could not be created back in the queue,
+// keeping their place by enqueue time. Tickets that reached MaxMatchAttempts, whose
+// client has closed or whose user queued again meanwhile are not requeued; those
+// dropped for their attempts are returned so their users can be told.
+func (m *Matchmaker) Requeue(tickets []*Ticket) (dropped []*Ticket) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ticket := range tickets {
+		ticket.Attempts++
+		if ticket.Client != nil && ticket.Client.Closed() {
+			continue
+		}
+		if _, queued := m.tickets[ticket.UserID]; queued {
+			continue
+		}
+		if ticket.Attempts >= MaxMatchAttempts {
+			dropped = append(dropped, ticket)
+			continue
+		}
+		m.tickets[ticket.UserID] = ticket
+	}
+	return dropped
+}
+
+// Dequeue removes a user's ticket from the queue
+func (m *Matchmaker) Dequeue(userID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.tickets, userID)
+}
+
+// RemoveClient removes any ticket queued from the given client
+func (m *Matchmaker) RemoveClient(c *client.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for userID, ticket := range m.tickets {
+		if ticket.Client == c {
+			delete(m.tickets, userID)
+		}
+	}
+}
+
+// Run matches queued tickets every TickInterval until ctx is cancelled
+func (m *Matchmaker) Run(ctx context.Context) {
+	ticker := time.NewTicker(TickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			matches := m.matchOnce(time.Now())
+
+			m.mu.Lock()
+			onMatch := m.onMatch
+			m.mu.Unlock()
+
+			if onMatch == nil {
+				if len(matches) > 0 {
+					log.Printf("[Matchmaker] %d matches found but no match handler set", len(matches))
+				}
+				continue
+			}
+			for _, match := range matches {
+				go onMatch(match)
+			}
+		}
+	}
+}
+
+// matchOnce groups compatible tickets, oldest first, and removes matched tickets from the queue
+func (m *Matchmaker) matchOnce(now time.Time) []*Match {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queued := make([]*Ticket, 0, len(m.tickets))
+	for _, ticket := range m.tickets {
+		queued = append(queued, ticket)
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		return queued[i].EnqueuedAt.Before(queued[j].EnqueuedAt)
+	})
+
+	matched := make(map[string]bool)
+	var matches []*Match
+
+	for _, anchor := range queued {
+		if matched[anchor.UserID] {
+			continue
+		}
+
+		group := []*Ticket{anchor}
+		for _, candidate := range queued {
+			if len(group) == anchor.Preferences.Size {
+				break
+			}
+			if candidate == anchor || matched[candidate.UserID] {
+				continue
+			}
+			if m.compatible(anchor, group, candidate, now) {
+				group = append(group, candidate)
+			}
+		}
+
+		if len(group) < anchor.Preferences.Size {
+			continue
+		}
+
+		for _, ticket := range group {
+			matched[ticket.UserID] = true
+			delete(m.tickets, ticket.UserID)
+		}
+		matches = append(matches, &Match{
+			Tickets:     group,
+			Preferences: anchor.Preferences,
+		})
+	}
+
+	return matches
+}
+
+// compatible reports whether candidate can join the anchor's group. The candidate must be
+// within the rating gap of every member, not just the anchor, so the group's whole rating
+// spread stays within the gap.
+func (m *Matchmaker) compatible(anchor *Ticket, group []*Ticket, candidate *Ticket, now time.Time) bool {
+	if anchor.Preferences != candidate.Preferences {
+		return false
+	}
+	if !m.useRating {
+		return true
+	}
+
+	gap := ratingGap(now.Sub(anchor.EnqueuedAt))
+	for _, member := range group {
+		if math.Abs(member.Rating-candidate.Rating) > gap {
+			return false
+		}
+	}
+	return true
+}
+
+// ratingGap returns the allowed rating difference after waiting for the given duration
+func ratingGap(waited time.Duration) float64 {
+	gap := InitialRatingGap + RatingGapStep*float64(waited/RatingGapEvery)
+	return math.Min(gap, MaxRatingGap)
+}
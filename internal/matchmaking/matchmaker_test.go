@@ -0,0 +1,166 @@
+package matchmaking
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+var (
+	base     = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	oneEasy  = Preferences{Size: 2, MaxEasyQuestions: 1}
+	twoEasy  = Preferences{Size: 2, MaxEasyQuestions: 2}
+	trioEasy = Preferences{Size: 3, MaxEasyQuestions: 1}
+)
+
+func ticket(userID string, prefs Preferences, rating float64, waited time.Duration) *Ticket {
+	return &Ticket{UserID: userID, Preferences: prefs, Rating: rating, EnqueuedAt: base.Add(-waited)}
+}
+
+// groups returns the user IDs of each match, in match order
+func groups(matches []*Match) [][]string {
+	var out [][]string
+	for _, match := range matches {
+		var ids []string
+		for _, t := range match.Tickets {
+			ids = append(ids, t.UserID)
+		}
+		sort.Strings(ids)
+		out = append(out, ids)
+	}
+	return out
+}
+
+func TestMatchOnce(t *testing.T) {
+	tests := []struct {
+		name      string
+		useRating bool
+		tickets   []*Ticket
+		want      [][]string
+		remaining int
+	}{
+		{
+			name:    "same preferences match",
+			tickets: []*Ticket{ticket("a", oneEasy, 0, 2*time.Second), ticket("b", oneEasy, 0, time.Second)},
+			want:    [][]string{{"a", "b"}},
+		},
+		{
+			name:      "different preferences don't match",
+			tickets:   []*Ticket{ticket("a", oneEasy, 0, 2*time.Second), ticket("b", twoEasy, 0, time.Second)},
+			remaining: 2,
+		},
+		{
+			name:      "a group waits until it is full",
+			tickets:   []*Ticket{ticket("a", trioEasy, 0, 2*time.Second), ticket("b", trioEasy, 0, time.Second)},
+			remaining: 2,
+		},
+		{
+			name: "oldest tickets are grouped first",
+			tickets: []*Ticket{
+				ticket("a", oneEasy, 0, 4*time.Second),
+				ticket("b", oneEasy, 0, 3*time.Second),
+				ticket("c", oneEasy, 0, 2*time.Second),
+			},
+			want:      [][]string{{"a", "b"}},
+			remaining: 1,
+		},
+		{
+			name:    "ratings are ignored when disabled",
+			tickets: []*Ticket{ticket("a", oneEasy, 1000, 0), ticket("b", oneEasy, 2000, 0)},
+			want:    [][]string{{"a", "b"}},
+		},
+		{
+			name:      "rating gap too wide for a new ticket",
+			useRating: true,
+			tickets:   []*Ticket{ticket("a", oneEasy, 1500, 0), ticket("b", oneEasy, 1650, 0)},
+			remaining: 2,
+		},
+		{
+			name:      "rating gap widens while the anchor waits",
+			useRating: true,
+			tickets:   []*Ticket{ticket("a", oneEasy, 1500, 20*time.Second), ticket("b", oneEasy, 1650, 0)},
+			want:      [][]string{{"a", "b"}},
+		},
+		{
+			name:      "candidates must be within the gap of every member",
+			useRating: true,
+			tickets: []*Ticket{
+				ticket("a", trioEasy, 1500, 2*time.Second),
+				ticket("b", trioEasy, 1590, time.Second),
+				ticket("c", trioEasy, 1680, 0),
+			},
+			remaining: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMatchmaker(tt.useRating)
+			for _, queued := range tt.tickets {
+				if _, err := m.Enqueue(queued); err != nil {
+					t.Fatalf("Enqueue(%s) error = %v", queued.UserID, err)
+				}
+			}
+
+			got := groups(m.matchOnce(base))
+			if len(got) != len(tt.want) {
+				t.Fatalf("matches = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if len(got[i]) != len(tt.want[i]) {
+					t.Fatalf("matches = %v, want %v", got, tt.want)
+				}
+				for j := range got[i] {
+					if got[i][j] != tt.want[i][j] {
+						t.Fatalf("matches = %v, want %v", got, tt.want)
+					}
+				}
+			}
+			if len(m.tickets) != tt.remaining {
+				t.Errorf("%d tickets left in the queue, want %d", len(m.tickets), tt.remaining)
+			}
+		})
+	}
+}
+
+func TestRatingGap(t *testing.T) {
+	tests := []struct {
+		waited time.Duration
+		want   float64
+	}{
+		{0, InitialRatingGap},
+		{RatingGapEvery - time.Second, InitialRatingGap},
+		{RatingGapEvery, InitialRatingGap + RatingGapStep},
+		{3 * RatingGapEvery, InitialRatingGap + 3*RatingGapStep},
+		{time.Hour, MaxRatingGap},
+	}
+
+	for _, tt := range tests {
+		if got := ratingGap(tt.waited); got != tt.want {
+			t.Errorf("ratingGap(%s) = %v, want %v", tt.waited, got, tt.want)
+		}
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	m := NewMatchmaker(false)
+	fresh := ticket("a", oneEasy, 0, 0)
+	exhausted := ticket("b", oneEasy, 0, 0)
+	exhausted.Attempts = MaxMatchAttempts - 1
+	requeued := ticket("c", oneEasy, 0, 0)
+	if _, err := m.Enqueue(ticket("c", twoEasy, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	dropped := m.Requeue([]*Ticket{fresh, exhausted, requeued})
+
+	if len(dropped) != 1 || dropped[0] != exhausted {
+		t.Errorf("dropped = %v, want only the exhausted ticket", dropped)
+	}
+	if m.tickets["a"] != fresh || fresh.Attempts != 1 {
+		t.Errorf("fresh ticket not requeued with one attempt")
+	}
+	if m.tickets["c"].Preferences != twoEasy {
+		t.Errorf("requeue replaced the user's newer ticket")
+	}
+}
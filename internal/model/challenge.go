@@ -82,6 +82,45 @@ type ChallengeDocument struct {
 	Config              *ChallengeConfig                 `bson:"config" json:"config"`
 	ProcessedProblemIds []string                         `bson:"processedProblemIds" json:"processedProblemIds"`
 	ProblemCount        int64                            `bson:"problemCount" json:"problemCount"`
+	SeriesID            string                           `bson:"seriesId,omitempty" json:"seriesId,omitempty"`
+	PreviousChallengeID string                           `bson:"previousChallengeId,omitempty" json:"previousChallengeId,omitempty"`
+	ExcludedProblemIds  []string                         `bson:"excludedProblemIds,omitempty" json:"excludedProblemIds,omitempty"` // problems already used earlier in the series
+	Version             int64                            `bson:"version" json:"version"`                                           // bumped on every Redis write, for optimistic concurrency
+}
+
+// ChallengeSummary is the public listing view of a challenge, without password, problems or submissions
+type ChallengeSummary struct {
+	ChallengeID      string `json:"challengeId"`
+	CreatorID        string `json:"creatorId"`
+	CreatedAt        int64  `json:"createdAt"`
+	Title            string `json:"title"`
+	IsPrivate        bool   `json:"isPrivate"`
+	Status           string `json:"status"`
+	TimeLimit        int64  `json:"timeLimit"`
+	StartTime        int64  `json:"startTime"`
+	ProblemCount     int64  `json:"problemCount"`
+	ParticipantCount int    `json:"participantCount"`
+	MaxUsers         int    `json:"maxUsers"`
+}
+
+// Summary returns the listing view of the challenge
+func (c *ChallengeDocument) Summary() ChallengeSummary {
+	summary := ChallengeSummary{
+		ChallengeID:      c.ChallengeID,
+		CreatorID:        c.CreatorID,
+		CreatedAt:        c.CreatedAt,
+		Title:            c.Title,
+		IsPrivate:        c.IsPrivate,
+		Status:           c.Status,
+		TimeLimit:        c.TimeLimit,
+		StartTime:        c.StartTime,
+		ProblemCount:     c.ProblemCount,
+		ParticipantCount: len(c.Participants),
+	}
+	if c.Config != nil {
+		summary.MaxUsers = c.Config.MaxUsers
+	}
+	return summary
 }
 
 type Submission struct {
@@ -112,5 +151,7 @@ type LeaderboardEntry struct {
 	UserID            string `json:"userId"`
 	ProblemsCompleted int    `json:"problemsCompleted"`
 	TotalScore        int    `json:"totalScore"`
+	TotalTimeTaken    int64  `json:"totalTimeTaken"`   // ms, tiebreak
+	LastSubmissionAt  int64  `json:"lastSubmissionAt"` // unix
 	Rank              int    `json:"rank"`
 }
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+const (
+	SeasonAllTime = "all-time"
+	SeasonFormat  = "2006-01" // monthly seasons
+)
+
+// SeasonFor returns the monthly season key a timestamp belongs to
+func SeasonFor(t time.Time) string {
+	return t.UTC().Format(SeasonFormat)
+}
+
+// ChallengeResult is a participant's final result in one ended challenge
+type ChallengeResult struct {
+	ChallengeID    string `bson:"challengeId" json:"challengeId"`
+	UserID         string `bson:"userId" json:"userId"`
+	Season         string `bson:"season" json:"season"`
+	Rank           int    `bson:"rank" json:"rank"`
+	Points         int    `bson:"points" json:"points"`
+	ProblemsSolved int    `bson:"problemsSolved" json:"problemsSolved"`
+	Won            bool   `bson:"won" json:"won"`
+	CreatedAt      int64  `bson:"createdAt" json:"createdAt"`
+}
+
+// GlobalLeaderboardEntry aggregates a user's results across challenges for a season
+type GlobalLeaderboardEntry struct {
+	Season           string `bson:"season" json:"season"`
+	UserID           string `bson:"userId" json:"userId"`
+	Wins             int    `bson:"wins" json:"wins"`
+	Points           int    `bson:"points" json:"points"`
+	ProblemsSolved   int    `bson:"problemsSolved" json:"problemsSolved"`
+	ChallengesPlayed int    `bson:"challengesPlayed" json:"challengesPlayed"`
+	UpdatedAt        int64  `bson:"updatedAt" json:"updatedAt"`
+	Rank             int    `bson:"-" json:"rank"`
+}
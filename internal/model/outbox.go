@@ -0,0 +1,12 @@
+package model
+
+// PersistJob is a pending or dead-lettered job persisting a finished challenge to MongoDB
+type PersistJob struct {
+	ChallengeID   string `json:"challengeId"`
+	Attempts      int    `json:"attempts"`
+	LastError     string `json:"lastError,omitempty"`
+	EnqueuedAt    int64  `json:"enqueuedAt"`              // unix ms
+	UpdatedAt     int64  `json:"updatedAt,omitempty"`     // unix ms of the last attempt
+	NextAttemptAt int64  `json:"nextAttemptAt,omitempty"` // unix ms; unset once dead-lettered
+	DeadAt        int64  `json:"deadAt,omitempty"`        // unix ms it was dead-lettered
+}
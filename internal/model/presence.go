@@ -0,0 +1,15 @@
+package model
+
+// ConnectionPresence is one live WebSocket connection of a user, on some node
+type ConnectionPresence struct {
+	ConnectionID  string `json:"connectionId"`
+	NodeID        string `json:"nodeId"`
+	LastHeartbeat int64  `json:"lastHeartbeat"`
+}
+
+// UserPresence is a user's live connections to a challenge across all nodes
+type UserPresence struct {
+	UserID        string               `json:"userId"`
+	Connections   []ConnectionPresence `json:"connections"`
+	LastHeartbeat int64                `json:"lastHeartbeat"`
+}
@@ -0,0 +1,22 @@
+package model
+
+// UserRating is a user's current competitive rating
+type UserRating struct {
+	UserID           string  `bson:"userId" json:"userId"`
+	Rating           float64 `bson:"rating" json:"rating"`
+	ChallengesPlayed int     `bson:"challengesPlayed" json:"challengesPlayed"`
+	UpdatedAt        int64   `bson:"updatedAt" json:"updatedAt"`
+}
+
+// RatingChange records how a single challenge changed a user's rating. Delta is what
+// was applied; OldRating and NewRating are informational, as the rating may have moved
+// by other challenges between computing and applying the change.
+type RatingChange struct {
+	UserID      string  `bson:"userId" json:"userId"`
+	ChallengeID string  `bson:"challengeId" json:"challengeId"`
+	OldRating   float64 `bson:"oldRating" json:"oldRating"`
+	NewRating   float64 `bson:"newRating" json:"newRating"`
+	Delta       float64 `bson:"delta" json:"delta"`
+	Rank        int     `bson:"rank" json:"rank"`
+	CreatedAt   int64   `bson:"createdAt" json:"createdAt"`
+}
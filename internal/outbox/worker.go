@@ -0,0 +1,183 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+)
+
+// Outcome counters, published with expvar and served at /admin/vars
+var (
+	jobsCompleted = expvar.NewInt("outbox_jobs_completed")
+	jobsFailed    = expvar.NewInt("outbox_jobs_failed")
+	jobsDead      = expvar.NewInt("outbox_jobs_dead")
+)
+
+// Store holds the queued persistence jobs; see repo.RedisRepository
+type Store interface {
+	ClaimPersistJobs(ctx context.Context, lease time.Duration, limit int) ([]model.PersistJob, error)
+	ClaimPersistJob(ctx context.Context, challengeID string, lease time.Duration) (*model.PersistJob, error)
+	CompletePersistJob(ctx context.Context, challengeID string) error
+	FailPersistJob(ctx context.Context, challengeID string, cause error, retryAt time.Time) error
+}
+
+// Config tunes a Worker; zero fields take the defaults below
+type Config struct {
+	PollInterval time.Duration // how often due jobs are claimed
+	Lease        time.Duration // how long a claimed job is hidden from other workers
+	BaseBackoff  time.Duration // delay before the first retry, doubled on each failure
+	MaxBackoff   time.Duration // cap on the retry delay
+	MaxAttempts  int           // attempts before a job is dead-lettered
+	BatchSize    int           // jobs claimed per poll
+}
+
+const (
+	DefaultPollInterval = time.Second
+	DefaultLease        = time.Minute
+	DefaultBaseBackoff  = time.Second
+	DefaultMaxBackoff   = 5 * time.Minute
+	DefaultMaxAttempts  = 10
+	DefaultBatchSize    = 20
+)
+
+// Worker runs queued persistence jobs, retrying failures with exponential backoff and
+// dead-lettering jobs that keep failing. Jobs are leased, so several nodes can run
+// workers against the same store and a job is retried if its worker dies.
+type Worker struct {
+	store   Store
+	cfg     Config
+	handler func(ctx context.Context, challengeID string) error
+	mu      sync.RWMutex
+}
+
+// NewWorker creates a Worker over store
+func NewWorker(store Store, cfg Config) *Worker {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultPollInterval
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = DefaultLease
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = DefaultBaseBackoff
+	}
+	if cfg.MaxBackoff < cfg.BaseBackoff {
+		cfg.MaxBackoff = max(DefaultMaxBackoff, cfg.BaseBackoff)
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	return &Worker{store: store, cfg: cfg}
+}
+
+// SetHandler sets the function persisting a challenge. It must be idempotent, since a
+// job is rerun if its worker dies before recording the outcome.
+func (w *Worker) SetHandler(handler func(ctx context.Context, challengeID string) error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.handler = handler
+}
+
+// Run claims and runs due jobs every poll interval until ctx is cancelled
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		w.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) poll(ctx context.Context) {
+	for ctx.Err() == nil {
+		jobs, err := w.store.ClaimPersistJobs(ctx, w.cfg.Lease, w.cfg.BatchSize)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Printf("[Outbox] %v", err)
+			}
+			return
+		}
+		for _, job := range jobs {
+			w.run(ctx, job)
+		}
+		if len(jobs) < w.cfg.BatchSize {
+			return
+		}
+	}
+}
+
+// Process runs a challenge's job now if it is due and not held by another worker, so
+// the caller sees it persisted without waiting for the next poll. A failure is
+// scheduled for retry like any other, and returned.
+func (w *Worker) Process(ctx context.Context, challengeID string) error {
+	job, err := w.store.ClaimPersistJob(ctx, challengeID, w.cfg.Lease)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return nil
+	}
+	return w.run(ctx, *job)
+}
+
+// run executes one claimed job and records its outcome
+func (w *Worker) run(ctx context.Context, job model.PersistJob) error {
+	w.mu.RLock()
+	handler := w.handler
+	w.mu.RUnlock()
+
+	if handler == nil {
+		return errors.New("no persistence handler set")
+	}
+
+	err := handler(ctx, job.ChallengeID)
+	if err == nil {
+		jobsCompleted.Add(1)
+		if err := w.store.CompletePersistJob(ctx, job.ChallengeID); err != nil {
+			// The lease runs out and the job is rerun, which the handler tolerates
+			log.Printf("[Outbox] %v", err)
+		}
+		return nil
+	}
+
+	attempts := job.Attempts + 1
+	var retryAt time.Time
+	if attempts < w.cfg.MaxAttempts {
+		retryAt = time.Now().Add(w.backoff(attempts))
+		jobsFailed.Add(1)
+		log.Printf("[Outbox] Persisting challenge %s failed (attempt %d/%d), retrying at %s: %v", job.ChallengeID, attempts, w.cfg.MaxAttempts, retryAt.Format(time.RFC3339), err)
+	} else {
+		jobsDead.Add(1)
+		log.Printf("[Outbox] Persisting challenge %s failed %d times, dead-lettered: %v", job.ChallengeID, attempts, err)
+	}
+
+	if ferr := w.store.FailPersistJob(ctx, job.ChallengeID, err, retryAt); ferr != nil {
+		log.Printf("[Outbox] %v", ferr)
+	}
+	return fmt.Errorf("failed to persist challenge %s: %w", job.ChallengeID, err)
+}
+
+// backoff is the delay after the given number of failed attempts
+func (w *Worker) backoff(attempts int) time.Duration {
+	delay := w.cfg.BaseBackoff
+	for i := 1; i < attempts && delay < w.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, w.cfg.MaxBackoff)
+}
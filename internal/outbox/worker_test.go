@@ -0,0 +1,190 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+)
+
+// fakeStore serves queued jobs and records the outcomes the worker reports
+type fakeStore struct {
+	jobs      map[string]*model.PersistJob
+	completed []string
+	failed    map[string]time.Time // challenge ID -> retryAt; zero when dead-lettered
+}
+
+func newFakeStore(jobs ...model.PersistJob) *fakeStore {
+	s := &fakeStore{jobs: make(map[string]*model.PersistJob), failed: make(map[string]time.Time)}
+	for i := range jobs {
+		s.jobs[jobs[i].ChallengeID] = &jobs[i]
+	}
+	return s
+}
+
+func (s *fakeStore) ClaimPersistJobs(_ context.Context, _ time.Duration, limit int) ([]model.PersistJob, error) {
+	var jobs []model.PersistJob
+	for _, job := range s.jobs {
+		if len(jobs) == limit {
+			break
+		}
+		jobs = append(jobs, *job)
+	}
+	for _, job := range jobs {
+		delete(s.jobs, job.ChallengeID)
+	}
+	return jobs, nil
+}
+
+func (s *fakeStore) ClaimPersistJob(_ context.Context, challengeID string, _ time.Duration) (*model.PersistJob, error) {
+	job, ok := s.jobs[challengeID]
+	if !ok {
+		return nil, nil
+	}
+	delete(s.jobs, challengeID)
+	return job, nil
+}
+
+func (s *fakeStore) CompletePersistJob(_ context.Context, challengeID string) error {
+	s.completed = append(s.completed, challengeID)
+	return nil
+}
+
+func (s *fakeStore) FailPersistJob(_ context.Context, challengeID string, _ error, retryAt time.Time) error {
+	s.failed[challengeID] = retryAt
+	return nil
+}
+
+func TestBackoff(t *testing.T) {
+	w := NewWorker(newFakeStore(), Config{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second})
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := w.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	errMongo := errors.New("mongo unavailable")
+
+	tests := []struct {
+		name       string
+		job        model.PersistJob
+		handlerErr error
+		wantErr    bool
+		completed  bool
+		retryAfter time.Duration // expected delay of the retry; -1 when none is scheduled
+		dead       bool
+	}{
+		{
+			name:       "success completes the job",
+			job:        model.PersistJob{ChallengeID: "c1"},
+			completed:  true,
+			retryAfter: -1,
+		},
+		{
+			name:       "first failure retries after the base backoff",
+			job:        model.PersistJob{ChallengeID: "c1"},
+			handlerErr: errMongo,
+			wantErr:    true,
+			retryAfter: time.Second,
+		},
+		{
+			name:       "later failures back off exponentially",
+			job:        model.PersistJob{ChallengeID: "c1", Attempts: 2},
+			handlerErr: errMongo,
+			wantErr:    true,
+			retryAfter: 4 * time.Second,
+		},
+		{
+			name:       "last attempt dead-letters the job",
+			job:        model.PersistJob{ChallengeID: "c1", Attempts: 4},
+			handlerErr: errMongo,
+			wantErr:    true,
+			dead:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore(tt.job)
+			w := NewWorker(store, Config{BaseBackoff: time.Second, MaxBackoff: time.Minute, MaxAttempts: 5})
+			w.SetHandler(func(context.Context, string) error { return tt.handlerErr })
+
+			start := time.Now()
+			err := w.Process(context.Background(), tt.job.ChallengeID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, tt.handlerErr) {
+				t.Errorf("Process() error = %v, want it to wrap %v", err, tt.handlerErr)
+			}
+
+			if got := len(store.completed) == 1; got != tt.completed {
+				t.Errorf("completed = %v, want %v", store.completed, tt.completed)
+			}
+
+			retryAt, failed := store.failed[tt.job.ChallengeID]
+			switch {
+			case tt.dead:
+				if !failed || !retryAt.IsZero() {
+					t.Errorf("job not dead-lettered: failed = %v, retryAt = %s", failed, retryAt)
+				}
+			case tt.retryAfter < 0:
+				if failed {
+					t.Errorf("unexpected failure recorded with retryAt %s", retryAt)
+				}
+			default:
+				if !failed {
+					t.Fatal("failure not recorded")
+				}
+				if delay := retryAt.Sub(start); delay < tt.retryAfter || delay > tt.retryAfter+time.Second {
+					t.Errorf("retry scheduled after %s, want %s", delay, tt.retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessWithoutJob(t *testing.T) {
+	w := NewWorker(newFakeStore(), Config{})
+	w.SetHandler(func(context.Context, string) error {
+		t.Error("handler called without a queued job")
+		return nil
+	})
+
+	if err := w.Process(context.Background(), "missing"); err != nil {
+		t.Errorf("Process() error = %v, want nil", err)
+	}
+}
+
+func TestPollRunsEveryDueJob(t *testing.T) {
+	store := newFakeStore(
+		model.PersistJob{ChallengeID: "c1"},
+		model.PersistJob{ChallengeID: "c2"},
+		model.PersistJob{ChallengeID: "c3"},
+	)
+	w := NewWorker(store, Config{BatchSize: 2})
+	w.SetHandler(func(context.Context, string) error { return nil })
+
+	w.poll(context.Background())
+
+	if len(store.completed) != 3 {
+		t.Errorf("completed %v, want all three jobs across batches", store.completed)
+	}
+}
@@ -0,0 +1,100 @@
+// Package presence tracks which users are connected to each challenge across all nodes.
+// Every node records its connections in Redis and heartbeats them; entries not refreshed
+// within the TTL are treated as offline, so a crashed node's users drop out on their own.
+package presence
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/repo"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/client"
+)
+
+// DefaultTTL is how long a connection stays present without a heartbeat
+const DefaultTTL = 30 * time.Second
+
+// Connections enumerates this node's live connections for heartbeats
+type Connections interface {
+	ForEachWSClient(fn func(challengeID, userID string, c *client.Client))
+}
+
+// Registry records this node's connections in the shared presence store
+type Registry struct {
+	redis  *repo.RedisRepository
+	local  Connections
+	nodeID string
+	ttl    time.Duration
+}
+
+// NewRegistry creates a registry for this node; ttl below one second means DefaultTTL
+func NewRegistry(redis *repo.RedisRepository, local Connections, nodeID string, ttl time.Duration) *Registry {
+	if ttl < time.Second {
+		ttl = DefaultTTL
+	}
+	return &Registry{
+		redis:  redis,
+		local:  local,
+		nodeID: nodeID,
+		ttl:    ttl,
+	}
+}
+
+// NodeID returns the ID this node records its connections under
+func (r *Registry) NodeID() string {
+	return r.nodeID
+}
+
+// Connect records a connection and reports whether it is the user's first live
+// connection to the challenge on any node
+func (r *Registry) Connect(ctx context.Context, challengeID, userID, connectionID string) (bool, error) {
+	return r.redis.ConnectPresence(ctx, challengeID, userID, r.nodeID, connectionID, r.ttl)
+}
+
+// Disconnect removes a connection and returns how many live connections the user
+// still has in the challenge on any node
+func (r *Registry) Disconnect(ctx context.Context, challengeID, userID, connectionID string) (int, error) {
+	return r.redis.DisconnectPresence(ctx, challengeID, userID, r.nodeID, connectionID, r.ttl)
+}
+
+// Online returns the users connected to a challenge on any node
+func (r *Registry) Online(ctx context.Context, challengeID string) ([]model.UserPresence, error) {
+	return r.redis.GetChallengePresence(ctx, challengeID, r.ttl)
+}
+
+// IsOnline reports whether a user is connected to a challenge on any node
+func (r *Registry) IsOnline(ctx context.Context, challengeID, userID string) (bool, error) {
+	return r.redis.IsUserPresent(ctx, challengeID, userID, r.ttl)
+}
+
+// Run heartbeats this node's connections every third of the TTL until ctx is done
+func (r *Registry) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.heartbeat(ctx)
+		}
+	}
+}
+
+func (r *Registry) heartbeat(ctx context.Context) {
+	var conns []repo.PresenceConnection
+	r.local.ForEachWSClient(func(challengeID, userID string, c *client.Client) {
+		conns = append(conns, repo.PresenceConnection{
+			ChallengeID:  challengeID,
+			UserID:       userID,
+			ConnectionID: c.ID(),
+		})
+	})
+
+	if err := r.redis.RefreshPresence(ctx, r.nodeID, conns, r.ttl); err != nil {
+		log.Printf("[Presence] heartbeat of %d connections failed: %v", len(conns), err)
+	}
+}
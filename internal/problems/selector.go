@@ -0,0 +1,90 @@
+package problems
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	problemPb "github.com/lijuuu/GlobalProtoXcode/ProblemsService"
+)
+
+// pickAttempts bounds how often a difficulty is re-requested to replace excluded problems
+const pickAttempts = 3
+
+// Selector picks random problems for challenges the service creates itself, such as
+// matchmaking matches and rematches, from the problems service
+type Selector struct {
+	client problemPb.ProblemsServiceClient
+}
+
+// NewSelector creates a Selector backed by the problems service client
+func NewSelector(client problemPb.ProblemsServiceClient) *Selector {
+	return &Selector{client: client}
+}
+
+// Pick returns random problem IDs, up to the given number per difficulty, never returning
+// an excluded ID. Fewer are returned when the pool of unexcluded problems is smaller;
+// picking none at all is an error.
+func (s *Selector) Pick(ctx context.Context, easy, medium, hard int, exclude []string) ([]string, error) {
+	if easy+medium+hard <= 0 {
+		return nil, errors.New("no problems requested")
+	}
+
+	seen := make(map[string]bool, len(exclude))
+	for _, id := range exclude {
+		seen[id] = true
+	}
+
+	// Difficulties are requested one at a time, since the response doesn't say which
+	// difficulty each problem has and excluded ones must be replaced in kind
+	var picked []string
+	for _, ratio := range []*problemPb.ProblemDifficultyRatio{
+		{Easy: int32(easy)},
+		{Medium: int32(medium)},
+		{Hard: int32(hard)},
+	} {
+		want := int(ratio.Easy + ratio.Medium + ratio.Hard)
+		got := 0
+		for attempt := 0; attempt < pickAttempts && got < want; attempt++ {
+			ids, err := s.request(ctx, ratio)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				if got < want && !seen[id] {
+					seen[id] = true
+					picked = append(picked, id)
+					got++
+				}
+			}
+			if len(ids) < want {
+				break // the pool has no more problems of this difficulty
+			}
+		}
+	}
+
+	if len(picked) == 0 {
+		return nil, errors.New("no problems available")
+	}
+	return picked, nil
+}
+
+// request asks the problems service for random problems in the given ratio
+func (s *Selector) request(ctx context.Context, ratio *problemPb.ProblemDifficultyRatio) ([]string, error) {
+	if ratio.Easy+ratio.Medium+ratio.Hard == 0 {
+		return nil, nil
+	}
+
+	resp, err := s.client.RandomProblemIDsGenWithDifficultyRatio(ctx, &problemPb.RandomProblemIDsGenWithDifficultyRatioRequest{
+		Qnratio: ratio,
+		TraceID: uuid.NewString(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to pick problems: %w", err)
+	}
+	if !resp.GetSuccess() && len(resp.GetProblemIds()) == 0 {
+		return nil, fmt.Errorf("failed to pick problems: %s", resp.GetMessage())
+	}
+	return resp.GetProblemIds(), nil
+}
@@ -0,0 +1,81 @@
+package rating
+
+import (
+	"math"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+)
+
+const (
+	DefaultRating = 1500.0
+	KFactor       = 32.0
+)
+
+// ComputeEloDeltas calculates rating changes from final standings using a pairwise Elo model.
+// Every participant is compared with every other participant; a better result counts as a win,
+// an equal one as a draw. The K factor is split across opponents so that a room of N players
+// moves ratings about as much as a single 1v1.
+// Users missing from currentRatings start at DefaultRating.
+func ComputeEloDeltas(standings []*model.LeaderboardEntry, currentRatings map[string]float64) map[string]float64 {
+	deltas := make(map[string]float64, len(standings))
+	if len(standings) < 2 {
+		return deltas
+	}
+
+	ratingOf := func(userID string) float64 {
+		if r, exists := currentRatings[userID]; exists {
+			return r
+		}
+		return DefaultRating
+	}
+
+	k := KFactor / float64(len(standings)-1)
+
+	for _, a := range standings {
+		ratingA := ratingOf(a.UserID)
+		delta := 0.0
+
+		for _, b := range standings {
+			if a.UserID == b.UserID {
+				continue
+			}
+
+			expected := 1 / (1 + math.Pow(10, (ratingOf(b.UserID)-ratingA)/400))
+
+			actual := 0.5
+			if c := CompareResults(a, b); c > 0 {
+				actual = 1
+			} else if c < 0 {
+				actual = 0
+			}
+
+			delta += k * (actual - expected)
+		}
+
+		deltas[a.UserID] = math.Round(delta*100) / 100
+	}
+
+	return deltas
+}
+
+// CompareResults orders two final entries by score, then problems completed, then total time,
+// returning 1 if a did better, -1 if b did and 0 for a draw. Ranks are not used, since
+// the leaderboard breaks exact ties on user ID to keep them unique.
+func CompareResults(a, b *model.LeaderboardEntry) int {
+	switch {
+	case a.TotalScore != b.TotalScore:
+		return sign(a.TotalScore > b.TotalScore)
+	case a.ProblemsCompleted != b.ProblemsCompleted:
+		return sign(a.ProblemsCompleted > b.ProblemsCompleted)
+	case a.TotalTimeTaken != b.TotalTimeTaken:
+		return sign(a.TotalTimeTaken < b.TotalTimeTaken)
+	}
+	return 0
+}
+
+func sign(better bool) int {
+	if better {
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,117 @@
+package rating
+
+import (
+	"math"
+	"testing"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+)
+
+func entry(userID string, score, problems int, timeTaken int64) *model.LeaderboardEntry {
+	return &model.LeaderboardEntry{UserID: userID, TotalScore: score, ProblemsCompleted: problems, TotalTimeTaken: timeTaken}
+}
+
+func TestCompareResults(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b *model.LeaderboardEntry
+		want int
+	}{
+		{"higher score wins", entry("a", 200, 1, 9000), entry("b", 100, 2, 1000), 1},
+		{"lower score loses", entry("a", 100, 2, 1000), entry("b", 200, 1, 9000), -1},
+		{"more problems break a score tie", entry("a", 100, 2, 9000), entry("b", 100, 1, 1000), 1},
+		{"less time breaks a score and problem tie", entry("a", 100, 2, 1000), entry("b", 100, 2, 2000), 1},
+		{"more time loses", entry("a", 100, 2, 2000), entry("b", 100, 2, 1000), -1},
+		{"equal results draw", entry("a", 100, 2, 1000), entry("b", 100, 2, 1000), 0},
+		{"rank is ignored", &model.LeaderboardEntry{UserID: "a", Rank: 1}, &model.LeaderboardEntry{UserID: "b", Rank: 2}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CompareResults(tt.a, tt.b); got != tt.want {
+				t.Errorf("CompareResults() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeEloDeltas(t *testing.T) {
+	tests := []struct {
+		name      string
+		standings []*model.LeaderboardEntry
+		ratings   map[string]float64
+		want      map[string]float64
+	}{
+		{
+			name:      "single participant",
+			standings: []*model.LeaderboardEntry{entry("a", 100, 1, 1000)},
+			want:      map[string]float64{},
+		},
+		{
+			name:      "1v1 win at equal ratings",
+			standings: []*model.LeaderboardEntry{entry("a", 200, 2, 1000), entry("b", 100, 1, 1000)},
+			want:      map[string]float64{"a": 16, "b": -16},
+		},
+		{
+			name:      "1v1 draw at equal ratings",
+			standings: []*model.LeaderboardEntry{entry("a", 100, 1, 1000), entry("b", 100, 1, 1000)},
+			want:      map[string]float64{"a": 0, "b": 0},
+		},
+		{
+			name:      "1v1 draw favours the lower rating",
+			standings: []*model.LeaderboardEntry{entry("a", 100, 1, 1000), entry("b", 100, 1, 1000)},
+			ratings:   map[string]float64{"a": 1600, "b": 1400},
+			want:      map[string]float64{"a": -8.31, "b": 8.31},
+		},
+		{
+			name:      "missing ratings start at the default",
+			standings: []*model.LeaderboardEntry{entry("a", 200, 2, 1000), entry("b", 100, 1, 1000)},
+			ratings:   map[string]float64{"b": DefaultRating},
+			want:      map[string]float64{"a": 16, "b": -16},
+		},
+		{
+			name:      "three players split K across opponents",
+			standings: []*model.LeaderboardEntry{entry("a", 300, 3, 1000), entry("b", 200, 2, 1000), entry("c", 100, 1, 1000)},
+			want:      map[string]float64{"a": 16, "b": 0, "c": -16},
+		},
+		{
+			name:      "three players with a tie for first",
+			standings: []*model.LeaderboardEntry{entry("a", 300, 3, 1000), entry("b", 300, 3, 1000), entry("c", 100, 1, 1000)},
+			want:      map[string]float64{"a": 8, "b": 8, "c": -16},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ComputeEloDeltas(tt.standings, tt.ratings)
+			if len(got) != len(tt.want) {
+				t.Fatalf("ComputeEloDeltas() = %v, want %v", got, tt.want)
+			}
+			for userID, want := range tt.want {
+				if got[userID] != want {
+					t.Errorf("delta for %s = %v, want %v", userID, got[userID], want)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeEloDeltasZeroSum(t *testing.T) {
+	standings := []*model.LeaderboardEntry{
+		entry("a", 400, 4, 5000),
+		entry("b", 300, 3, 4000),
+		entry("c", 300, 3, 4000),
+		entry("d", 100, 1, 2000),
+		entry("e", 0, 0, 0),
+	}
+	ratings := map[string]float64{"a": 1420, "b": 1710, "c": 1500, "d": 1333, "e": 1904}
+
+	sum := 0.0
+	for _, delta := range ComputeEloDeltas(standings, ratings) {
+		sum += delta
+	}
+	// Each delta is rounded to cents, so the sum may drift by half a cent per player
+	if math.Abs(sum) > 0.005*float64(len(standings)) {
+		t.Errorf("deltas sum to %v, want 0", sum)
+	}
+}
@@ -0,0 +1,151 @@
+package rematch
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/client"
+)
+
+const (
+	RematchTimeout = 2 * time.Minute
+)
+
+// Rematch is a pending request to replay a finished challenge with the same participants
+type Rematch struct {
+	PreviousChallenge *model.ChallengeDocument
+	RequestedBy       string
+	Required          map[string]bool           // userID -> true for every prior participant
+	Accepted          map[string]*client.Client // userID -> client that accepted
+	CreatedAt         time.Time
+}
+
+// AcceptedUserIDs returns the sorted IDs of users who have accepted
+func (r *Rematch) AcceptedUserIDs() []string {
+	ids := make([]string, 0, len(r.Accepted))
+	for userID := range r.Accepted {
+		ids = append(ids, userID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// RequiredUserIDs returns the sorted IDs of all users who must accept
+func (r *Rematch) RequiredUserIDs() []string {
+	ids := make([]string, 0, len(r.Required))
+	for userID := range r.Required {
+		ids = append(ids, userID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Manager tracks pending rematches keyed by the previous challenge ID
+type Manager struct {
+	pending map[string]*Rematch
+	onReady func(*Rematch)
+	mu      sync.Mutex
+}
+
+// NewManager creates a new rematch Manager instance
+func NewManager() *Manager {
+	return &Manager{
+		pending: make(map[string]*Rematch),
+	}
+}
+
+// SetReadyHandler sets the callback invoked once every prior participant has accepted
+func (m *Manager) SetReadyHandler(onReady func(*Rematch)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onReady = onReady
+}
+
+// Request starts a rematch for a finished challenge, or accepts it if one is already pending.
+// Returns a copy of the rematch state and whether all participants have now accepted.
+func (m *Manager) Request(previous *model.ChallengeDocument, userID string, c *client.Client) (*Rematch, bool, error) {
+	if previous == nil {
+		return nil, false, errors.New("previous challenge is required")
+	}
+	if len(previous.Participants) < 2 {
+		return nil, false, errors.New("rematch requires at least two participants")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending, exists := m.pending[previous.ChallengeID]
+	if !exists || time.Since(pending.CreatedAt) > RematchTimeout {
+		required := make(map[string]bool, len(previous.Participants))
+		for participantID := range previous.Participants {
+			required[participantID] = true
+		}
+
+		pending = &Rematch{
+			PreviousChallenge: previous,
+			RequestedBy:       userID,
+			Required:          required,
+			Accepted:          make(map[string]*client.Client),
+			CreatedAt:         time.Now(),
+		}
+		m.pending[previous.ChallengeID] = pending
+	}
+
+	return m.accept(pending, userID, c)
+}
+
+// Accept records a user's acceptance of a pending rematch.
+// Returns a copy of the rematch state and whether all participants have now accepted.
+func (m *Manager) Accept(previousChallengeID, userID string, c *client.Client) (*Rematch, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending, exists := m.pending[previousChallengeID]
+	if !exists {
+		return nil, false, errors.New("no rematch requested for this challenge")
+	}
+	if time.Since(pending.CreatedAt) > RematchTimeout {
+		delete(m.pending, previousChallengeID)
+		return nil, false, errors.New("rematch request expired")
+	}
+
+	return m.accept(pending, userID, c)
+}
+
+// accept must be called with m.mu held
+func (m *Manager) accept(pending *Rematch, userID string, c *client.Client) (*Rematch, bool, error) {
+	if !pending.Required[userID] {
+		return nil, false, errors.New("user was not a participant in this challenge")
+	}
+
+	pending.Accepted[userID] = c
+
+	snapshot := &Rematch{
+		PreviousChallenge: pending.PreviousChallenge,
+		RequestedBy:       pending.RequestedBy,
+		Required:          make(map[string]bool, len(pending.Required)),
+		Accepted:          make(map[string]*client.Client, len(pending.Accepted)),
+		CreatedAt:         pending.CreatedAt,
+	}
+	for id := range pending.Required {
+		snapshot.Required[id] = true
+	}
+	for id, accepted := range pending.Accepted {
+		snapshot.Accepted[id] = accepted
+	}
+
+	if len(pending.Accepted) < len(pending.Required) {
+		return snapshot, false, nil
+	}
+
+	delete(m.pending, pending.PreviousChallenge.ChallengeID)
+	if m.onReady != nil {
+		go m.onReady(snapshot)
+	}
+
+	return snapshot, true, nil
+}
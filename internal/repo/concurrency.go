@@ -0,0 +1,118 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultTxMaxAttempts bounds how often a challenge mutation is retried on contention
+const DefaultTxMaxAttempts = 10
+
+var (
+	// ErrChallengeNotFound is returned when a challenge is not in Redis
+	ErrChallengeNotFound = errors.New("challenge not found")
+	// ErrChallengeConflict is returned when a challenge changed concurrently and the
+	// write could not be applied within the retry budget, or a stale version was written
+	ErrChallengeConflict = errors.New("challenge was modified concurrently")
+)
+
+// Contention counters, published with expvar and served at /admin/vars
+var (
+	challengeTxCommits   = expvar.NewInt("redis_challenge_tx_commits")
+	challengeTxConflicts = expvar.NewInt("redis_challenge_tx_conflicts")
+	challengeTxExhausted = expvar.NewInt("redis_challenge_tx_exhausted")
+)
+
+// SetTxMaxAttempts sets how many times a challenge mutation is attempted before giving up
+func (r *RedisRepository) SetTxMaxAttempts(n int) {
+	if n > 0 {
+		r.txMaxAttempts = n
+	}
+}
+
+// MutateChallenge applies fn to the stored challenge as an optimistic transaction: the
+// challenge is WATCHed while fn runs and only the fields it changed are written with
+// MULTI/EXEC, provided no one else wrote it meanwhile; otherwise the read-modify-write
+// is retried with backoff. fn may run several times and must only modify the document
+// it is given; an error from fn aborts without writing. Every successful write bumps
+// the challenge's Version and refreshes its TTL.
+func (r *RedisRepository) MutateChallenge(ctx context.Context, challengeID string, fn func(*model.ChallengeDocument) error) (*model.ChallengeDocument, error) {
+	key := challengeKey(challengeID)
+
+	maxAttempts := r.txMaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultTxMaxAttempts
+	}
+
+	// Every write bumps the version in the challenge hash, so watching it alone detects
+	// concurrent changes to any part of the challenge
+	var result model.ChallengeDocument
+	txf := func(tx *redis.Tx) error {
+		before, err := loadChallenge(ctx, tx, challengeID)
+		if err != nil {
+			return err
+		}
+
+		challenge, err := decodeChallenge(before)
+		if err != nil {
+			return err
+		}
+
+		version := challenge.Version
+		if err := fn(&challenge); err != nil {
+			return err
+		}
+		challenge.Version = version + 1
+
+		after, err := encodeChallenge(&challenge)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			writeChallengeDiff(ctx, pipe, challengeID, before, after)
+			r.writeExpiry(ctx, pipe, challengeID, &challenge, after, writtenSubmissionKeys(challengeID, before, after))
+			return nil
+		})
+		if err == nil {
+			result = challenge
+		}
+		return err
+	}
+
+	watch := func() error { return r.client.Watch(ctx, txf, key) }
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err := r.withLegacyMigration(ctx, challengeID, watch)
+		if err == nil {
+			challengeTxCommits.Add(1)
+			return &result, nil
+		}
+		if !errors.Is(err, redis.TxFailedErr) {
+			return nil, err
+		}
+
+		challengeTxConflicts.Add(1)
+		if attempt == maxAttempts {
+			break
+		}
+
+		// Jittered linear backoff spreads out writers contending for the same challenge
+		backoff := time.Duration(attempt)*time.Millisecond + rand.N(2*time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+
+	challengeTxExhausted.Add(1)
+	return nil, fmt.Errorf("%w: gave up on challenge %s after %d attempts", ErrChallengeConflict, challengeID, maxAttempts)
+}
@@ -0,0 +1,198 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultEventStreamMaxLen bounds each challenge's event stream
+const DefaultEventStreamMaxLen = 1000
+
+const (
+	// eventLockTTL releases a publisher's lock if its node dies mid-broadcast
+	eventLockTTL = 5 * time.Second
+	// eventLockWait bounds how long a broadcast waits for the lock
+	eventLockWait = 2 * time.Second
+)
+
+// ErrEventLockTimeout is returned when a challenge's event lock could not be taken in time
+var ErrEventLockTimeout = errors.New("timed out waiting for challenge event lock")
+
+// ChallengeEvent is a sequenced challenge broadcast kept for replay
+type ChallengeEvent struct {
+	Seq     int64
+	Type    string
+	Payload json.RawMessage
+}
+
+// appendEventScript assigns the next sequence number and appends the event under
+// stream ID <seq>-0 in one step, so concurrent broadcasts can't reorder the stream.
+// Both keys take the TTL of the challenge hash (KEYS[3]), so events of a challenge
+// nobody writes to again expire with it; a challenge without a TTL is queued for
+// persistence and keeps its events too. If the challenge is gone, ARGV[4] is used.
+var appendEventScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[3], seq .. '-0', 'type', ARGV[1], 'payload', ARGV[2])
+local ttl = redis.call('PTTL', KEYS[3])
+if ttl == -2 then
+	ttl = tonumber(ARGV[4])
+end
+if ttl > 0 then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+	redis.call('PEXPIRE', KEYS[2], ttl)
+end
+return seq
+`)
+
+// releaseEventLockScript deletes the lock only if it is still held by the caller's token
+var releaseEventLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func eventLockKey(challengeID string) string {
+	return fmt.Sprintf("challenge_events_lock:%s", challengeID)
+}
+
+func eventSeqKey(challengeID string) string {
+	return fmt.Sprintf("challenge_seq:%s", challengeID)
+}
+
+func eventStreamKey(challengeID string) string {
+	return fmt.Sprintf("challenge_events:%s", challengeID)
+}
+
+// SetEventStreamMaxLen sets how many events are kept per challenge for replay
+func (r *RedisRepository) SetEventStreamMaxLen(n int64) {
+	if n > 0 {
+		r.eventStreamMaxLen = n
+	}
+}
+
+// AppendChallengeEvent assigns the next sequence number for a challenge, appends the
+// event to its bounded stream and calls deliver with the sequence number. A per-challenge
+// lock is held across all three, on every node, so events are delivered in sequence
+// order; deliver must publish the event before it returns. deliver is not called if
+// the event could not be appended.
+func (r *RedisRepository) AppendChallengeEvent(ctx context.Context, challengeID, eventType string, payload []byte, deliver func(seq int64)) (int64, error) {
+	maxLen := r.eventStreamMaxLen
+	if maxLen <= 0 {
+		maxLen = DefaultEventStreamMaxLen
+	}
+
+	unlock, err := r.lockChallengeEvents(ctx, challengeID)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	// Events of a challenge missing from Redis keep the data TTL of one without a time limit
+	fallbackTTL := ChallengeTTL(&model.ChallengeDocument{}) + r.expiryGrace
+
+	keys := []string{eventSeqKey(challengeID), eventStreamKey(challengeID), challengeKey(challengeID)}
+	seq, err := appendEventScript.Run(ctx, r.client, keys, eventType, payload, maxLen, fallbackTTL.Milliseconds()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to append challenge event: %w", err)
+	}
+	deliver(seq)
+	return seq, nil
+}
+
+// lockChallengeEvents takes the challenge's event lock, retrying until eventLockWait
+// passes, and returns the function that releases it
+func (r *RedisRepository) lockChallengeEvents(ctx context.Context, challengeID string) (func(), error) {
+	key := eventLockKey(challengeID)
+	token := uuid.NewString()
+	deadline := time.Now().Add(eventLockWait)
+
+	for backoff := time.Millisecond; ; backoff = min(backoff*2, 50*time.Millisecond) {
+		ok, err := r.client.SetNX(ctx, key, token, eventLockTTL).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock challenge events: %w", err)
+		}
+		if ok {
+			break
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, ErrEventLockTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+
+	return func() {
+		if err := releaseEventLockScript.Run(context.Background(), r.client, []string{key}, token).Err(); err != nil {
+			log.Printf("[Redis] failed to release event lock of challenge %s: %v", challengeID, err)
+		}
+	}, nil
+}
+
+// LatestChallengeSeq returns the sequence number of the last event broadcast for a challenge
+func (r *RedisRepository) LatestChallengeSeq(ctx context.Context, challengeID string) (int64, error) {
+	seq, err := r.client.Get(ctx, eventSeqKey(challengeID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get challenge sequence: %w", err)
+	}
+	return seq, nil
+}
+
+// ChallengeEventsSince returns the events after afterSeq, oldest first, and the latest
+// sequence number they bring the caller to: the last returned event's, or the current
+// sequence if there are none, so the two never disagree. complete is false when some of
+// those events have already been trimmed from the stream, in which case the caller must
+// fall back to a full snapshot.
+func (r *RedisRepository) ChallengeEventsSince(ctx context.Context, challengeID string, afterSeq int64) (events []ChallengeEvent, latest int64, complete bool, err error) {
+	latest, err = r.LatestChallengeSeq(ctx, challengeID)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if afterSeq > latest || afterSeq < 0 {
+		return nil, latest, false, nil
+	}
+	if afterSeq == latest {
+		return nil, latest, true, nil
+	}
+
+	entries, err := r.client.XRange(ctx, eventStreamKey(challengeID), fmt.Sprintf("%d-0", afterSeq+1), "+").Result()
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to read challenge events: %w", err)
+	}
+
+	events = make([]ChallengeEvent, 0, len(entries))
+	for _, entry := range entries {
+		seqStr, _, _ := strings.Cut(entry.ID, "-")
+		seq, err := strconv.ParseInt(seqStr, 10, 64)
+		if err != nil {
+			return nil, 0, false, fmt.Errorf("invalid challenge event id %q: %w", entry.ID, err)
+		}
+		eventType, _ := entry.Values["type"].(string)
+		payload, _ := entry.Values["payload"].(string)
+		events = append(events, ChallengeEvent{Seq: seq, Type: eventType, Payload: json.RawMessage(payload)})
+	}
+
+	// The first missed event must still be in the stream for the replay to be gapless
+	if len(events) == 0 || events[0].Seq != afterSeq+1 {
+		return nil, latest, false, nil
+	}
+
+	// Events appended after the sequence was read are included, so they set the latest
+	return events, events[len(events)-1].Seq, true, nil
+}
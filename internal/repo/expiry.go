@@ -0,0 +1,211 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/constants"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// Challenges expire after TimeLimit + BufferTime without writes. Expiry is tracked by
+// a marker key, challenge_expiry:<id>; the challenge's own keys live DefaultExpiryGrace
+// longer, so the node that handles the marker's expired notification can still persist
+// the challenge before cleaning it up. If no node handles it, the data expires on its own.
+// Challenges in a final status are waiting in the persistence outbox instead, so their
+// data is kept without a TTL until the outbox persists and deletes it.
+
+// DefaultExpiryGrace is how long a challenge's data outlives its expiry marker
+const DefaultExpiryGrace = time.Hour
+
+// expiryClaimTTL bounds how long one node holds the right to clean up an expired challenge
+const expiryClaimTTL = 5 * time.Minute
+
+const expiryKeyPrefix = "challenge_expiry:"
+
+func expiryKey(challengeID string) string {
+	return expiryKeyPrefix + challengeID
+}
+
+func expiryClaimKey(challengeID string) string {
+	return fmt.Sprintf("challenge_expiry_claim:%s", challengeID)
+}
+
+// ChallengeTTL is how long a challenge is kept after its last write: its time limit plus
+// the buffer, or the default match length plus the buffer when it has no time limit
+func ChallengeTTL(challenge *model.ChallengeDocument) time.Duration {
+	timeLimit := time.Duration(challenge.TimeLimit) * time.Millisecond
+	if timeLimit <= 0 {
+		timeLimit = constants.MatchTimeLimit
+	}
+	return timeLimit + constants.BufferTime
+}
+
+// SetExpiryGrace sets how long a challenge's data outlives its expiry marker
+func (r *RedisRepository) SetExpiryGrace(d time.Duration) {
+	if d > 0 {
+		r.expiryGrace = d
+	}
+}
+
+// writeExpiry queues the TTL refresh for a challenge after a write. Submission hashes
+// are refreshed when written, which covers them for the rest of the challenge because
+// submissions stop once its time limit passes. A challenge in a final status has its
+// TTLs removed, as it is queued for persistence.
+func (r *RedisRepository) writeExpiry(ctx context.Context, pipe redis.Pipeliner, challengeID string, challenge *model.ChallengeDocument, layout challengeLayout, written []string) {
+	if isFinalStatus(challenge.Status) {
+		pipe.Del(ctx, expiryKey(challengeID))
+		for _, key := range append(challengeKeys(challengeID, layout), eventSeqKey(challengeID), eventStreamKey(challengeID)) {
+			pipe.Persist(ctx, key)
+		}
+		return
+	}
+
+	ttl := ChallengeTTL(challenge)
+	dataTTL := ttl + r.expiryGrace
+
+	pipe.Set(ctx, expiryKey(challengeID), 1, ttl)
+	for _, key := range []string{challengeKey(challengeID), participantsKey(challengeID), eventSeqKey(challengeID), eventStreamKey(challengeID)} {
+		pipe.PExpire(ctx, key, dataTTL)
+	}
+	for _, key := range written {
+		pipe.PExpire(ctx, key, dataTTL)
+	}
+}
+
+// writtenSubmissionKeys lists the submission hashes a diff from before to after writes
+func writtenSubmissionKeys(challengeID string, before, after challengeLayout) []string {
+	var keys []string
+	for userID, submissions := range after.submissions {
+		if _, ok := after.participants[userID]; !ok {
+			continue
+		}
+		old := before.submissions[userID]
+		for problemID, value := range submissions {
+			if old[problemID] != value {
+				keys = append(keys, submissionsKey(challengeID, userID))
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// EnsureChallengeExpiry gives every indexed challenge without an expiry marker a TTL,
+// for challenges written before TTLs were introduced, and drops index entries left by
+// challenges that expired while no node was listening. Finished challenges without a
+// marker are queued for persistence instead. It returns how many it updated.
+func (r *RedisRepository) EnsureChallengeExpiry(ctx context.Context) (int, error) {
+	challengeIDs, err := r.GetActiveChallenges(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, challengeID := range challengeIDs {
+		exists, err := r.client.Exists(ctx, expiryKey(challengeID), outboxJobKey(challengeID)).Result()
+		if err != nil {
+			return updated, fmt.Errorf("failed to check expiry of challenge %s: %w", challengeID, err)
+		}
+		if exists > 0 {
+			continue // Has a TTL, or is already queued for persistence
+		}
+
+		layout, err := loadChallenge(ctx, r.client, challengeID)
+		if errors.Is(err, ErrChallengeNotFound) {
+			// Expired while no node was listening; drop what is left of it
+			if err := r.DeleteChallenge(ctx, challengeID); err != nil {
+				log.Printf("Warning: Failed to clean up expired challenge %s: %v", challengeID, err)
+			}
+			continue
+		}
+		if err != nil {
+			continue // Unreadable, e.g. still in the legacy layout
+		}
+		challenge, err := decodeChallenge(layout)
+		if err != nil {
+			continue
+		}
+
+		_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.writeExpiry(ctx, pipe, challengeID, &challenge, layout, challengeKeys(challengeID, layout))
+			if isFinalStatus(challenge.Status) {
+				// Finished before the outbox existed; queue it now
+				queuePersistJob(ctx, pipe, challengeID, time.Now())
+			}
+			return nil
+		})
+		if err != nil {
+			return updated, fmt.Errorf("failed to set expiry of challenge %s: %w", challengeID, err)
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// ClaimChallengeExpiry reports whether this node won the right to clean up an expired
+// challenge; every node is notified of the expiry, but only one should act on it
+func (r *RedisRepository) ClaimChallengeExpiry(ctx context.Context, challengeID string) (bool, error) {
+	claimed, err := r.client.SetNX(ctx, expiryClaimKey(challengeID), 1, expiryClaimTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim expired challenge %s: %w", challengeID, err)
+	}
+	return claimed, nil
+}
+
+// WatchChallengeExpiry calls fn with the ID of each challenge whose expiry marker expires,
+// until ctx is cancelled. It enables expired-key notifications if the server has them
+// off; where CONFIG is not allowed they must be enabled with notify-keyspace-events Ex.
+func (r *RedisRepository) WatchChallengeExpiry(ctx context.Context, fn func(challengeID string)) error {
+	if err := r.enableExpiredNotifications(ctx); err != nil {
+		log.Printf("Warning: Could not enable Redis expired-key notifications, expiry cleanup relies on the server config: %v", err)
+	}
+
+	channel := fmt.Sprintf("__keyevent@%d__:expired", r.client.Options().DB)
+	pubsub := r.client.Subscribe(ctx, channel)
+	defer pubsub.Close()
+
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+	}
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			if challengeID, ok := strings.CutPrefix(msg.Payload, expiryKeyPrefix); ok {
+				fn(challengeID)
+			}
+		}
+	}
+}
+
+// enableExpiredNotifications adds the E and x flags to notify-keyspace-events, keeping any already set
+func (r *RedisRepository) enableExpiredNotifications(ctx context.Context) error {
+	current, err := r.client.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return err
+	}
+
+	flags := current["notify-keyspace-events"]
+	if strings.Contains(flags, "E") && strings.ContainsAny(flags, "xA") {
+		return nil
+	}
+	if !strings.Contains(flags, "E") {
+		flags += "E"
+	}
+	if !strings.ContainsAny(flags, "xA") {
+		flags += "x"
+	}
+	return r.client.ConfigSet(ctx, "notify-keyspace-events", flags).Err()
+}
@@ -0,0 +1,263 @@
+package repo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Challenge indexes are sorted sets kept in step with every challenge write. Members are
+// "<createdAt>|<challengeID>" with score 0, so each index sorts by creation time and is
+// paged by member with ZRANGE BYLEX instead of scanning the keyspace:
+//
+//	challenge_index:all                          every challenge
+//	challenge_index:status:<status>              by status
+//	challenge_index:creator:<creatorID>          by creator
+//	challenge_index:visibility:<public|private>  by privacy
+
+const allIndexKey = "challenge_index:all"
+
+// ErrInvalidCursor is returned when a listing cursor was not issued by ListChallengeIDs
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+func statusIndexKey(status string) string {
+	return fmt.Sprintf("challenge_index:status:%s", status)
+}
+
+func creatorIndexKey(creatorID string) string {
+	return fmt.Sprintf("challenge_index:creator:%s", creatorID)
+}
+
+func visibilityIndexKey(private bool) string {
+	if private {
+		return "challenge_index:visibility:private"
+	}
+	return "challenge_index:visibility:public"
+}
+
+func indexMember(createdAt int64, challengeID string) string {
+	return fmt.Sprintf("%019d|%s", createdAt, challengeID)
+}
+
+func memberChallengeID(member string) string {
+	_, challengeID, _ := strings.Cut(member, "|")
+	return challengeID
+}
+
+// challengeIndexes returns the indexes listing a challenge and its member in them,
+// given its encoded top-level fields. A challenge without fields is in no index.
+func challengeIndexes(challengeID string, meta map[string]string) (string, []string) {
+	if len(meta) == 0 {
+		return "", nil
+	}
+
+	var (
+		createdAt int64
+		status    string
+		creatorID string
+		isPrivate bool
+	)
+	_ = json.Unmarshal([]byte(meta["createdAt"]), &createdAt)
+	_ = json.Unmarshal([]byte(meta["status"]), &status)
+	_ = json.Unmarshal([]byte(meta["creatorId"]), &creatorID)
+	_ = json.Unmarshal([]byte(meta["isPrivate"]), &isPrivate)
+
+	keys := []string{allIndexKey, statusIndexKey(status), visibilityIndexKey(isPrivate)}
+	if creatorID != "" {
+		keys = append(keys, creatorIndexKey(creatorID))
+	}
+	return indexMember(createdAt, challengeID), keys
+}
+
+// writeIndexDiff queues the index updates for a challenge whose fields changed from before to after
+func writeIndexDiff(ctx context.Context, pipe redis.Pipeliner, challengeID string, before, after map[string]string) {
+	oldMember, oldKeys := challengeIndexes(challengeID, before)
+	newMember, newKeys := challengeIndexes(challengeID, after)
+
+	for _, key := range oldKeys {
+		if oldMember != newMember || !containsKey(newKeys, key) {
+			pipe.ZRem(ctx, key, oldMember)
+		}
+	}
+	for _, key := range newKeys {
+		if oldMember != newMember || !containsKey(oldKeys, key) {
+			pipe.ZAdd(ctx, key, redis.Z{Member: newMember})
+		}
+	}
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// dropFromIndexes removes a challenge whose data is already gone from every index. Its
+// entries can't be derived from its fields any more, so they are searched for; this only
+// happens for challenges that expired without being cleaned up.
+func (r *RedisRepository) dropFromIndexes(ctx context.Context, challengeID string) error {
+	var member string
+	members := r.client.ZScan(ctx, allIndexKey, 0, "*|"+challengeID, 0).Iterator()
+	for members.Next(ctx) {
+		// ZSCAN yields members and scores alternately; scores never contain "|"
+		if v := members.Val(); memberChallengeID(v) == challengeID {
+			member = v
+			break
+		}
+	}
+	if err := members.Err(); err != nil {
+		return fmt.Errorf("failed to find challenge %s in index: %w", challengeID, err)
+	}
+	if member == "" {
+		return nil
+	}
+
+	pipe := r.client.Pipeline()
+	indexes := r.client.ScanType(ctx, 0, "challenge_index:*", 100, "zset").Iterator()
+	for indexes.Next(ctx) {
+		pipe.ZRem(ctx, indexes.Val(), member)
+	}
+	if err := indexes.Err(); err != nil {
+		return fmt.Errorf("failed to scan challenge indexes: %w", err)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to drop challenge %s from indexes: %w", challengeID, err)
+	}
+	return nil
+}
+
+// ChallengeFilter selects challenges by their indexes; zero fields match every challenge
+type ChallengeFilter struct {
+	Status    string
+	CreatorID string
+	IsPrivate *bool
+}
+
+func (f ChallengeFilter) indexKeys() []string {
+	var keys []string
+	if f.Status != "" {
+		keys = append(keys, statusIndexKey(f.Status))
+	}
+	if f.CreatorID != "" {
+		keys = append(keys, creatorIndexKey(f.CreatorID))
+	}
+	if f.IsPrivate != nil {
+		keys = append(keys, visibilityIndexKey(*f.IsPrivate))
+	}
+	if len(keys) == 0 {
+		keys = append(keys, allIndexKey)
+	}
+	return keys
+}
+
+// ChallengePage is one page of a challenge listing, newest first. NextCursor is empty
+// on the last page; Total counts every challenge matching the filter.
+type ChallengePage struct {
+	ChallengeIDs []string
+	NextCursor   string
+	Total        int64
+}
+
+// ListChallengeIDs returns up to limit IDs of challenges matching filter, newest first.
+// The page starts after cursor, the NextCursor of an earlier page, when one is given,
+// else after skipping offset challenges. Cursors stay valid while challenges are added
+// or removed, so prefer them over offsets for walking a listing.
+func (r *RedisRepository) ListChallengeIDs(ctx context.Context, filter ChallengeFilter, cursor string, offset, limit int) (ChallengePage, error) {
+	stop := "+"
+	if cursor != "" {
+		after, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || !strings.Contains(string(after), "|") {
+			return ChallengePage{}, ErrInvalidCursor
+		}
+		stop = "(" + string(after)
+		offset = 0
+	}
+	if limit <= 0 || offset < 0 {
+		limit, offset = 0, 0
+	}
+
+	keys := filter.indexKeys()
+	key := keys[0]
+	if len(keys) > 1 {
+		// Intersect into a scratch index that lives only for this transaction
+		key = "challenge_index:tmp:" + uuid.NewString()
+	}
+
+	var countCmd *redis.IntCmd
+	var rangeCmd *redis.StringSliceCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(keys) > 1 {
+			countCmd = pipe.ZInterStore(ctx, key, &redis.ZStore{Keys: keys})
+		} else {
+			countCmd = pipe.ZCard(ctx, key)
+		}
+		if limit > 0 {
+			// One extra member tells whether another page follows. go-redis takes the
+			// bounds in ascending order and swaps them for REV.
+			rangeCmd = pipe.ZRangeArgs(ctx, redis.ZRangeArgs{
+				Key:    key,
+				Start:  "-",
+				Stop:   stop,
+				ByLex:  true,
+				Rev:    true,
+				Offset: int64(offset),
+				Count:  int64(limit) + 1,
+			})
+		}
+		if len(keys) > 1 {
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return ChallengePage{}, fmt.Errorf("failed to list challenges: %w", err)
+	}
+
+	page := ChallengePage{Total: countCmd.Val()}
+	if rangeCmd == nil {
+		return page, nil
+	}
+
+	members := rangeCmd.Val()
+	if len(members) > limit {
+		members = members[:limit]
+		page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(members[limit-1]))
+	}
+	page.ChallengeIDs = make([]string, len(members))
+	for i, member := range members {
+		page.ChallengeIDs[i] = memberChallengeID(member)
+	}
+	return page, nil
+}
+
+// CountChallenges returns how many challenges match filter
+func (r *RedisRepository) CountChallenges(ctx context.Context, filter ChallengeFilter) (int64, error) {
+	page, err := r.ListChallengeIDs(ctx, filter, "", 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	return page.Total, nil
+}
+
+// indexedChallengeIDs returns every challenge ID in an index, newest first
+func (r *RedisRepository) indexedChallengeIDs(ctx context.Context, key string) ([]string, error) {
+	members, err := r.client.ZRevRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read challenge index: %w", err)
+	}
+
+	challengeIDs := make([]string, len(members))
+	for i, member := range members {
+		challengeIDs[i] = memberChallengeID(member)
+	}
+	return challengeIDs, nil
+}
@@ -0,0 +1,324 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// A challenge is stored across several keys, so a join or submission only rewrites
+// the fields it changed instead of the whole document:
+//
+//	challenge:<id>                        hash of the top-level fields, each JSON-encoded
+//	challenge_participants:<id>           hash of userID -> ParticipantMetadata JSON
+//	challenge_submissions:<id>:<userID>   hash of problemID -> Submission JSON
+//
+// Submissions are kept per participant, so removing a participant removes theirs too.
+// The challenge indexes and persistence outbox are updated in the same transaction;
+// see index.go and outbox.go.
+
+func challengeKey(challengeID string) string {
+	return fmt.Sprintf("challenge:%s", challengeID)
+}
+
+func participantsKey(challengeID string) string {
+	return fmt.Sprintf("challenge_participants:%s", challengeID)
+}
+
+func submissionsKey(challengeID, userID string) string {
+	return fmt.Sprintf("challenge_submissions:%s:%s", challengeID, userID)
+}
+
+// challengeLayout is a challenge encoded as the field values of its hashes
+type challengeLayout struct {
+	meta         map[string]string
+	participants map[string]string
+	submissions  map[string]map[string]string
+}
+
+func encodeChallenge(challenge *model.ChallengeDocument) (challengeLayout, error) {
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return challengeLayout{}, fmt.Errorf("failed to marshal challenge: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return challengeLayout{}, fmt.Errorf("failed to split challenge fields: %w", err)
+	}
+	delete(fields, "participants")
+	delete(fields, "submissions")
+
+	layout := challengeLayout{
+		meta:         make(map[string]string, len(fields)),
+		participants: make(map[string]string, len(challenge.Participants)),
+		submissions:  make(map[string]map[string]string, len(challenge.Submissions)),
+	}
+	for name, value := range fields {
+		layout.meta[name] = string(value)
+	}
+	for userID, participant := range challenge.Participants {
+		data, err := json.Marshal(participant)
+		if err != nil {
+			return challengeLayout{}, fmt.Errorf("failed to marshal participant %s: %w", userID, err)
+		}
+		layout.participants[userID] = string(data)
+	}
+	for userID, submissions := range challenge.Submissions {
+		if len(submissions) == 0 {
+			continue
+		}
+		encoded := make(map[string]string, len(submissions))
+		for problemID, submission := range submissions {
+			data, err := json.Marshal(submission)
+			if err != nil {
+				return challengeLayout{}, fmt.Errorf("failed to marshal submission %s of %s: %w", problemID, userID, err)
+			}
+			encoded[problemID] = string(data)
+		}
+		layout.submissions[userID] = encoded
+	}
+	return layout, nil
+}
+
+func decodeChallenge(layout challengeLayout) (model.ChallengeDocument, error) {
+	fields := make(map[string]json.RawMessage, len(layout.meta))
+	for name, value := range layout.meta {
+		fields[name] = json.RawMessage(value)
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return model.ChallengeDocument{}, fmt.Errorf("failed to join challenge fields: %w", err)
+	}
+
+	var challenge model.ChallengeDocument
+	if err := json.Unmarshal(data, &challenge); err != nil {
+		return model.ChallengeDocument{}, fmt.Errorf("failed to unmarshal challenge: %w", err)
+	}
+
+	challenge.Participants = make(map[string]*model.ParticipantMetadata, len(layout.participants))
+	for userID, value := range layout.participants {
+		var participant *model.ParticipantMetadata
+		if err := json.Unmarshal([]byte(value), &participant); err != nil {
+			return model.ChallengeDocument{}, fmt.Errorf("failed to unmarshal participant %s: %w", userID, err)
+		}
+		challenge.Participants[userID] = participant
+	}
+
+	challenge.Submissions = make(map[string]map[string]model.Submission, len(layout.submissions))
+	for userID, encoded := range layout.submissions {
+		submissions := make(map[string]model.Submission, len(encoded))
+		for problemID, value := range encoded {
+			var submission model.Submission
+			if err := json.Unmarshal([]byte(value), &submission); err != nil {
+				return model.ChallengeDocument{}, fmt.Errorf("failed to unmarshal submission %s of %s: %w", problemID, userID, err)
+			}
+			submissions[problemID] = submission
+		}
+		challenge.Submissions[userID] = submissions
+	}
+	return challenge, nil
+}
+
+// loadChallenge reads a challenge's hashes with two round trips. It works on a client
+// or on a WATCHing transaction.
+func loadChallenge(ctx context.Context, c redis.Cmdable, challengeID string) (challengeLayout, error) {
+	var metaCmd, participantsCmd *redis.MapStringStringCmd
+	_, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		metaCmd = pipe.HGetAll(ctx, challengeKey(challengeID))
+		participantsCmd = pipe.HGetAll(ctx, participantsKey(challengeID))
+		return nil
+	})
+	if err != nil {
+		return challengeLayout{}, fmt.Errorf("failed to get challenge: %w", err)
+	}
+	if len(metaCmd.Val()) == 0 {
+		return challengeLayout{}, ErrChallengeNotFound
+	}
+
+	layout := challengeLayout{
+		meta:         metaCmd.Val(),
+		participants: participantsCmd.Val(),
+		submissions:  make(map[string]map[string]string),
+	}
+
+	if len(layout.participants) == 0 {
+		return layout, nil
+	}
+	submissionCmds := make(map[string]*redis.MapStringStringCmd, len(layout.participants))
+	_, err = c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for userID := range layout.participants {
+			submissionCmds[userID] = pipe.HGetAll(ctx, submissionsKey(challengeID, userID))
+		}
+		return nil
+	})
+	if err != nil {
+		return challengeLayout{}, fmt.Errorf("failed to get submissions: %w", err)
+	}
+	for userID, cmd := range submissionCmds {
+		if len(cmd.Val()) > 0 {
+			layout.submissions[userID] = cmd.Val()
+		}
+	}
+	return layout, nil
+}
+
+// writeChallengeDiff queues the commands turning the stored before layout into after.
+// Only changed fields are written; submissions of users who aren't participants are dropped.
+func writeChallengeDiff(ctx context.Context, pipe redis.Pipeliner, challengeID string, before, after challengeLayout) {
+	writeHashDiff(ctx, pipe, challengeKey(challengeID), before.meta, after.meta)
+	writeHashDiff(ctx, pipe, participantsKey(challengeID), before.participants, after.participants)
+
+	for userID, submissions := range after.submissions {
+		if _, ok := after.participants[userID]; ok {
+			writeHashDiff(ctx, pipe, submissionsKey(challengeID, userID), before.submissions[userID], submissions)
+		}
+	}
+	for userID := range before.participants {
+		_, participant := after.participants[userID]
+		_, submitted := after.submissions[userID]
+		if !participant || (!submitted && before.submissions[userID] != nil) {
+			pipe.Del(ctx, submissionsKey(challengeID, userID))
+		}
+	}
+
+	writeIndexDiff(ctx, pipe, challengeID, before.meta, after.meta)
+	writeOutboxDiff(ctx, pipe, challengeID, before.meta, after.meta)
+}
+
+func writeHashDiff(ctx context.Context, pipe redis.Pipeliner, key string, before, after map[string]string) {
+	var changed []any
+	for field, value := range after {
+		if old, ok := before[field]; !ok || old != value {
+			changed = append(changed, field, value)
+		}
+	}
+	if len(changed) > 0 {
+		pipe.HSet(ctx, key, changed...)
+	}
+
+	var removed []string
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			removed = append(removed, field)
+		}
+	}
+	if len(removed) > 0 {
+		pipe.HDel(ctx, key, removed...)
+	}
+}
+
+// challengeKeys lists every key holding part of a challenge's document
+func challengeKeys(challengeID string, layout challengeLayout) []string {
+	keys := []string{challengeKey(challengeID), participantsKey(challengeID)}
+	for userID := range layout.participants {
+		keys = append(keys, submissionsKey(challengeID, userID))
+	}
+	return keys
+}
+
+func isWrongType(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "WRONGTYPE")
+}
+
+// MigrateChallengeLayout converts challenges stored as a single JSON string by earlier
+// versions into the hash layout, keeping their TTL. Challenges already migrated are
+// skipped, so it is safe to run on every start; legacy keys written afterwards by
+// nodes not yet upgraded are migrated lazily on first access.
+func (r *RedisRepository) MigrateChallengeLayout(ctx context.Context) (int, error) {
+	migrated := 0
+	var cursor uint64
+	for {
+		keys, next, err := r.client.ScanType(ctx, cursor, "challenge:*", 100, "string").Result()
+		if err != nil {
+			return migrated, fmt.Errorf("failed to scan challenge keys: %w", err)
+		}
+		for _, key := range keys {
+			ok, err := r.migrateChallenge(ctx, strings.TrimPrefix(key, "challenge:"))
+			if err != nil {
+				return migrated, err
+			}
+			if ok {
+				migrated++
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return migrated, nil
+		}
+	}
+}
+
+// migrateChallenge converts one legacy JSON challenge, reporting whether it did
+func (r *RedisRepository) migrateChallenge(ctx context.Context, challengeID string) (bool, error) {
+	key := challengeKey(challengeID)
+	migrated := false
+
+	err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+		keyType, err := tx.Type(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if keyType != "string" {
+			return nil
+		}
+
+		data, err := tx.Get(ctx, key).Bytes()
+		if err != nil {
+			return err
+		}
+		ttl, err := tx.PTTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+
+		var challenge model.ChallengeDocument
+		if err := json.Unmarshal(data, &challenge); err != nil {
+			return fmt.Errorf("failed to unmarshal legacy challenge %s: %w", challengeID, err)
+		}
+		layout, err := encodeChallenge(&challenge)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			writeChallengeDiff(ctx, pipe, challengeID, challengeLayout{}, layout)
+			if ttl > 0 {
+				for _, k := range challengeKeys(challengeID, layout) {
+					pipe.PExpire(ctx, k, ttl)
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			migrated = true
+		}
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		// Someone else migrated or rewrote it meanwhile
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to migrate challenge %s: %w", challengeID, err)
+	}
+	return migrated, nil
+}
+
+// withLegacyMigration runs op, migrating the challenge and retrying once if it is
+// still stored in the legacy JSON layout
+func (r *RedisRepository) withLegacyMigration(ctx context.Context, challengeID string, op func() error) error {
+	err := op()
+	if !isWrongType(err) {
+		return err
+	}
+	if _, err := r.migrateChallenge(ctx, challengeID); err != nil {
+		return err
+	}
+	return op()
+}
@@ -4,59 +4,134 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
 	// "github.com/google/uuid"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 type MongoRepository struct {
-	challenges *mongo.Collection
+	challenges        *mongo.Collection
+	ratings           *mongo.Collection
+	ratingHistory     *mongo.Collection
+	challengeResults  *mongo.Collection
+	globalLeaderboard *mongo.Collection
+
+	client       *mongo.Client
+	dbName       string
+	writeTimeout time.Duration
+}
+
+// MongoWritePolicy sets how MongoDB writes are acknowledged. W is a number of nodes,
+// "majority" or a tag set name, or empty for the server default; Timeout bounds each
+// challenge persistence write, zero meaning only the caller's context applies.
+type MongoWritePolicy struct {
+	W       string
+	Journal bool
+	Timeout time.Duration
 }
 
 func NewMongoRepository(client *mongo.Client, dbName string) *MongoRepository {
-	return &MongoRepository{
-		challenges: client.Database(dbName).Collection("challenges"),
+	r := &MongoRepository{client: client, dbName: dbName}
+	r.useDatabase(client.Database(dbName))
+	return r
+}
+
+func (r *MongoRepository) useDatabase(db *mongo.Database) {
+	r.challenges = db.Collection("challenges")
+	r.ratings = db.Collection("ratings")
+	r.ratingHistory = db.Collection("ratingHistory")
+	r.challengeResults = db.Collection("challengeResults")
+	r.globalLeaderboard = db.Collection("globalLeaderboard")
+}
+
+// SetWritePolicy applies a write concern to every collection and a timeout to challenge persistence
+func (r *MongoRepository) SetWritePolicy(policy MongoWritePolicy) {
+	r.writeTimeout = policy.Timeout
+
+	var wc *writeconcern.WriteConcern
+	if policy.W != "" || policy.Journal {
+		wc = &writeconcern.WriteConcern{}
+		if n, err := strconv.Atoi(policy.W); err == nil {
+			wc.W = n
+		} else if policy.W != "" {
+			wc.W = policy.W
+		}
+		if policy.Journal {
+			journal := true
+			wc.Journal = &journal
+		}
 	}
+	r.useDatabase(r.client.Database(r.dbName, options.Database().SetWriteConcern(wc)))
 }
 
-// PersistChallengeFromRedis persists challenge data from Redis to MongoDB for historical storage
+// EnsureIndexes creates the indexes the repository relies on. The unique challengeId
+// index makes concurrent persistence of the same challenge update one document instead
+// of inserting duplicates; the unique rating and leaderboard indexes make their updates
+// idempotent per challenge. An index fails to build while duplicates exist.
+func (r *MongoRepository) EnsureIndexes(ctx context.Context) error {
+	indexes := []struct {
+		collection *mongo.Collection
+		keys       bson.D
+		name       string
+	}{
+		{r.challenges, bson.D{{Key: "challengeId", Value: 1}}, "challengeId_unique"},
+		{r.ratings, bson.D{{Key: "userId", Value: 1}}, "userId_unique"},
+		{r.ratingHistory, bson.D{{Key: "challengeId", Value: 1}, {Key: "userId", Value: 1}}, "challengeId_userId_unique"},
+		{r.challengeResults, bson.D{{Key: "challengeId", Value: 1}, {Key: "userId", Value: 1}}, "challengeId_userId_unique"},
+		{r.globalLeaderboard, bson.D{{Key: "season", Value: 1}, {Key: "userId", Value: 1}}, "season_userId_unique"},
+	}
+
+	var errs []error
+	for _, index := range indexes {
+		_, err := index.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    index.keys,
+			Options: options.Index().SetUnique(true).SetName(index.name),
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to create unique index %s on %s: %w", index.name, index.collection.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PersistChallengeFromRedis persists challenge data from Redis to MongoDB for historical storage.
+// It is a single upsert setting every field, so persisting the same challenge again, e.g.
+// when a persistence job is retried, brings the stored document fully up to date instead
+// of failing or duplicating it. Fields only set in MongoDB, like a rematch's seriesId,
+// are kept.
 func (r *MongoRepository) PersistChallengeFromRedis(ctx context.Context, challenge *model.ChallengeDocument) error {
 	if challenge == nil {
 		return errors.New("challenge cannot be nil")
 	}
 
-	// Check if challenge already exists in MongoDB
-	filter := bson.M{"challengeId": challenge.ChallengeID}
-	var existingChallenge model.ChallengeDocument
-	err := r.challenges.FindOne(ctx, filter).Decode(&existingChallenge)
-
-	if err == mongo.ErrNoDocuments {
-		// Challenge doesn't exist, insert it
-		_, err = r.challenges.InsertOne(ctx, challenge)
-		return err
-	} else if err != nil {
-		// Some other error occurred
-		return fmt.Errorf("failed to check existing challenge: %w", err)
+	data, err := bson.Marshal(challenge)
+	if err != nil {
+		return fmt.Errorf("failed to marshal challenge: %w", err)
 	}
+	var fields bson.M
+	if err := bson.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("failed to marshal challenge: %w", err)
+	}
+	delete(fields, "_id")
 
-	// Challenge exists, update it
-	update := bson.M{
-		"$set": bson.M{
-			"status":              challenge.Status,
-			"participants":        challenge.Participants,
-			"submissions":         challenge.Submissions,
-			"leaderboard":         challenge.Leaderboard,
-			"startTime":           challenge.StartTime,
-			"processedProblemIds": challenge.ProcessedProblemIds,
-			"problemCount":        challenge.ProblemCount,
-		},
+	if r.writeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.writeTimeout)
+		defer cancel()
 	}
 
-	_, err = r.challenges.UpdateOne(ctx, filter, update)
-	return err
+	filter := bson.M{"challengeId": challenge.ChallengeID}
+	update := bson.M{"$set": fields}
+	if _, err := r.challenges.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		return fmt.Errorf("failed to upsert challenge: %w", err)
+	}
+	return nil
 }
 
 // GetChallengeHistory returns challenge history; toggle it using isPrivate
@@ -176,3 +251,266 @@ func (r *MongoRepository) GetOwnersActiveChallenges(ctx context.Context, userID
 	return results, nil
 }
 
+
+// SetChallengeSeries links a persisted challenge to a rematch series
+func (r *MongoRepository) SetChallengeSeries(ctx context.Context, challengeID, seriesID string) error {
+	filter := bson.M{"challengeId": challengeID}
+	update := bson.M{
+		"$set": bson.M{
+			"seriesId": seriesID,
+		},
+	}
+
+	_, err := r.challenges.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// GetUserRatings returns the current ratings for the given users; users without a rating are omitted
+func (r *MongoRepository) GetUserRatings(ctx context.Context, userIDs []string) (map[string]model.UserRating, error) {
+	ratings := make(map[string]model.UserRating, len(userIDs))
+	if len(userIDs) == 0 {
+		return ratings, nil
+	}
+
+	cursor, err := r.ratings.Find(ctx, bson.M{"userId": bson.M{"$in": userIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []model.UserRating
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	for _, rating := range results {
+		ratings[rating.UserID] = rating
+	}
+	return ratings, nil
+}
+
+// GetUserRating returns a single user's current rating
+func (r *MongoRepository) GetUserRating(ctx context.Context, userID string) (model.UserRating, error) {
+	var result model.UserRating
+	err := r.ratings.FindOne(ctx, bson.M{"userId": userID}).Decode(&result)
+	return result, err
+}
+
+// appliedChallengesKept bounds the per-user list of challenges already folded into a
+// rating or leaderboard entry; it only has to cover retries of recent challenges
+const appliedChallengesKept = 100
+
+// ApplyRatingChanges records per-challenge rating deltas and adds them to each user's
+// current rating; users without a rating start at startRating. It is idempotent per
+// challenge and safe to run concurrently: the first changes recorded for a user in a
+// challenge win, and a user's rating only takes a challenge's delta once. Deltas are
+// added rather than ratings overwritten, so challenges ending together or a late retry
+// never undo another challenge's change; OldRating and NewRating in the history are
+// informational. It returns the changes this call applied, with the resulting ratings.
+func (r *MongoRepository) ApplyRatingChanges(ctx context.Context, challengeID string, changes []model.RatingChange, startRating float64) ([]model.RatingChange, error) {
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	for _, change := range changes {
+		filter := bson.M{"challengeId": challengeID, "userId": change.UserID}
+		update := bson.M{"$setOnInsert": change}
+		_, err := r.ratingHistory.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("failed to record rating change for user %s: %w", change.UserID, err)
+		}
+	}
+
+	// Apply what was recorded, which may come from an earlier or concurrent call
+	cursor, err := r.ratingHistory.Find(ctx, bson.M{"challengeId": challengeID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rating history: %w", err)
+	}
+	var recorded []model.RatingChange
+	if err := cursor.All(ctx, &recorded); err != nil {
+		return nil, fmt.Errorf("failed to read rating history: %w", err)
+	}
+
+	var applied []model.RatingChange
+	for _, change := range recorded {
+		// Create the rating first, so the guarded update below never has to upsert it
+		// from nothing and always adds to an existing rating
+		_, err := r.ratings.UpdateOne(ctx, bson.M{"userId": change.UserID}, bson.M{
+			"$setOnInsert": bson.M{"rating": startRating, "challengesPlayed": 0},
+		}, options.Update().SetUpsert(true))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return applied, fmt.Errorf("failed to create rating for user %s: %w", change.UserID, err)
+		}
+
+		update := bson.M{
+			"$set": bson.M{
+				"updatedAt": change.CreatedAt,
+			},
+			"$inc": bson.M{
+				"rating":           change.Delta,
+				"challengesPlayed": 1,
+			},
+		}
+
+		ok, err := applyOnce(ctx, r.ratings, bson.M{"userId": change.UserID}, challengeID, update)
+		if err != nil {
+			return applied, fmt.Errorf("failed to update rating for user %s: %w", change.UserID, err)
+		}
+		if !ok {
+			continue
+		}
+
+		// Report the rating the user actually has now, which may include other challenges
+		current, err := r.GetUserRating(ctx, change.UserID)
+		if err == nil {
+			change.NewRating = current.Rating
+			change.OldRating = current.Rating - change.Delta
+		}
+		applied = append(applied, change)
+	}
+
+	return applied, nil
+}
+
+// applyOnce upserts the document matching filter with update unless the challenge was
+// already applied to it, reporting whether it applied it now. The filter must match a
+// unique index, so that an upsert racing an applied document fails instead of inserting.
+func applyOnce(ctx context.Context, collection *mongo.Collection, filter bson.M, challengeID string, update bson.M) (bool, error) {
+	guarded := bson.M{"appliedChallengeIds": bson.M{"$ne": challengeID}}
+	for key, value := range filter {
+		guarded[key] = value
+	}
+	update["$push"] = bson.M{
+		"appliedChallengeIds": bson.M{"$each": []string{challengeID}, "$slice": -appliedChallengesKept},
+	}
+
+	result, err := collection.UpdateOne(ctx, guarded, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil // already applied
+	}
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0 || result.UpsertedCount > 0, nil
+}
+
+// GetRatingHistory returns a user's rating changes, newest first
+func (r *MongoRepository) GetRatingHistory(ctx context.Context, userID string, page, pageSize int) ([]model.RatingChange, error) {
+	if page < 1 || pageSize < 1 || userID == "" {
+		return nil, errors.New("invalid pagination or userID")
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(int64((page - 1) * pageSize)).
+		SetLimit(int64(pageSize))
+
+	cursor, err := r.ratingHistory.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []model.RatingChange
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ApplyChallengeResults records per-user challenge results and increments the
+// all-time and seasonal global leaderboard entries. Like ApplyRatingChanges it is
+// idempotent per challenge: the first result recorded for a user wins, and each
+// leaderboard entry counts a challenge once.
+func (r *MongoRepository) ApplyChallengeResults(ctx context.Context, challengeID string, results []model.ChallengeResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	for _, result := range results {
+		filter := bson.M{"challengeId": challengeID, "userId": result.UserID}
+		update := bson.M{"$setOnInsert": result}
+		_, err := r.challengeResults.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("failed to record challenge result for user %s: %w", result.UserID, err)
+		}
+	}
+
+	cursor, err := r.challengeResults.Find(ctx, bson.M{"challengeId": challengeID})
+	if err != nil {
+		return fmt.Errorf("failed to read challenge results: %w", err)
+	}
+	var recorded []model.ChallengeResult
+	if err := cursor.All(ctx, &recorded); err != nil {
+		return fmt.Errorf("failed to read challenge results: %w", err)
+	}
+
+	for _, result := range recorded {
+		wins := 0
+		if result.Won {
+			wins = 1
+		}
+
+		for _, season := range []string{model.SeasonAllTime, result.Season} {
+			update := bson.M{
+				"$set": bson.M{
+					"updatedAt": result.CreatedAt,
+				},
+				"$inc": bson.M{
+					"wins":             wins,
+					"points":           result.Points,
+					"problemsSolved":   result.ProblemsSolved,
+					"challengesPlayed": 1,
+				},
+			}
+
+			filter := bson.M{"season": season, "userId": result.UserID}
+			if _, err := applyOnce(ctx, r.globalLeaderboard, filter, challengeID, update); err != nil {
+				return fmt.Errorf("failed to update %s leaderboard for user %s: %w", season, result.UserID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetGlobalLeaderboard returns a page of a season's leaderboard ranked by points, wins and problems solved
+func (r *MongoRepository) GetGlobalLeaderboard(ctx context.Context, season string, page, pageSize int) ([]model.GlobalLeaderboardEntry, int64, error) {
+	if page < 1 || pageSize < 1 || season == "" {
+		return nil, 0, errors.New("invalid pagination or season")
+	}
+
+	filter := bson.M{"season": season}
+
+	total, err := r.globalLeaderboard.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	skip := (page - 1) * pageSize
+	opts := options.Find().
+		SetSort(bson.D{
+			{Key: "points", Value: -1},
+			{Key: "wins", Value: -1},
+			{Key: "problemsSolved", Value: -1},
+			{Key: "userId", Value: 1},
+		}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(pageSize))
+
+	cursor, err := r.globalLeaderboard.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []model.GlobalLeaderboardEntry
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, 0, err
+	}
+
+	for i := range results {
+		results[i].Rank = skip + i + 1
+	}
+	return results, total, nil
+}
@@ -0,0 +1,235 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// A challenge reaching a final status is queued for persistence to MongoDB in the same
+// transaction as the status change, so the job can't be lost between the two:
+//
+//	persist_outbox:pending     sorted set of challenge IDs scored by next attempt (unix ms)
+//	persist_outbox:dead        sorted set of dead-lettered challenge IDs scored by when (unix ms)
+//	persist_outbox:job:<id>    hash of attempts, lastError, enqueuedAt and updatedAt
+//
+// The challenge's Redis data is kept without a TTL until the job completes.
+
+const (
+	outboxPendingKey = "persist_outbox:pending"
+	outboxDeadKey    = "persist_outbox:dead"
+)
+
+func outboxJobKey(challengeID string) string {
+	return fmt.Sprintf("persist_outbox:job:%s", challengeID)
+}
+
+// isFinalStatus reports whether a challenge in this status is done and due for persistence
+func isFinalStatus(status string) bool {
+	switch status {
+	case model.ChallengeEnded, model.ChallengeAbandon, model.ChallengeForfieted:
+		return true
+	}
+	return false
+}
+
+func metaStatus(meta map[string]string) string {
+	var status string
+	_ = json.Unmarshal([]byte(meta["status"]), &status)
+	return status
+}
+
+// writeOutboxDiff queues a persistence job when a write moves a challenge into a final status
+func writeOutboxDiff(ctx context.Context, pipe redis.Pipeliner, challengeID string, before, after map[string]string) {
+	if len(after) == 0 || !isFinalStatus(metaStatus(after)) || isFinalStatus(metaStatus(before)) {
+		return
+	}
+	queuePersistJob(ctx, pipe, challengeID, time.Now())
+}
+
+func queuePersistJob(ctx context.Context, pipe redis.Pipeliner, challengeID string, now time.Time) {
+	pipe.ZAddNX(ctx, outboxPendingKey, redis.Z{Score: float64(now.UnixMilli()), Member: challengeID})
+	pipe.HSetNX(ctx, outboxJobKey(challengeID), "enqueuedAt", now.UnixMilli())
+}
+
+// claimDueScript leases up to ARGV[3] due jobs by pushing their next attempt to the lease
+// end, so a worker that dies mid-job only delays it
+var claimDueScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[3]))
+for _, id in ipairs(due) do
+	redis.call('ZADD', KEYS[1], 'XX', ARGV[2], id)
+end
+return due
+`)
+
+// claimOneScript leases one job if it is due
+var claimOneScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score and tonumber(score) <= tonumber(ARGV[2]) then
+	redis.call('ZADD', KEYS[1], 'XX', ARGV[3], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// ClaimPersistJobs leases up to limit due persistence jobs for the lease duration
+func (r *RedisRepository) ClaimPersistJobs(ctx context.Context, lease time.Duration, limit int) ([]model.PersistJob, error) {
+	now := time.Now()
+	ids, err := claimDueScript.Run(ctx, r.client, []string{outboxPendingKey}, now.UnixMilli(), now.Add(lease).UnixMilli(), limit).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim persistence jobs: %w", err)
+	}
+	return r.persistJobs(ctx, ids, nil)
+}
+
+// ClaimPersistJob leases one persistence job if it is due, returning nil if it is not
+// queued, not yet due or already leased
+func (r *RedisRepository) ClaimPersistJob(ctx context.Context, challengeID string, lease time.Duration) (*model.PersistJob, error) {
+	now := time.Now()
+	claimed, err := claimOneScript.Run(ctx, r.client, []string{outboxPendingKey}, challengeID, now.UnixMilli(), now.Add(lease).UnixMilli()).Int()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim persistence job %s: %w", challengeID, err)
+	}
+	if claimed == 0 {
+		return nil, nil
+	}
+
+	jobs, err := r.persistJobs(ctx, []string{challengeID}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+// CompletePersistJob removes a persistence job once the challenge is in MongoDB
+func (r *RedisRepository) CompletePersistJob(ctx context.Context, challengeID string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, outboxPendingKey, challengeID)
+		pipe.ZRem(ctx, outboxDeadKey, challengeID)
+		pipe.Del(ctx, outboxJobKey(challengeID))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete persistence job %s: %w", challengeID, err)
+	}
+	return nil
+}
+
+// FailPersistJob records a failed attempt and schedules the job for retryAt, or moves it
+// to the dead-letter set if retryAt is zero
+func (r *RedisRepository) FailPersistJob(ctx context.Context, challengeID string, cause error, retryAt time.Time) error {
+	now := time.Now()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		key := outboxJobKey(challengeID)
+		pipe.HIncrBy(ctx, key, "attempts", 1)
+		pipe.HSet(ctx, key, "lastError", cause.Error(), "updatedAt", now.UnixMilli())
+		if retryAt.IsZero() {
+			pipe.ZRem(ctx, outboxPendingKey, challengeID)
+			pipe.ZAdd(ctx, outboxDeadKey, redis.Z{Score: float64(now.UnixMilli()), Member: challengeID})
+		} else {
+			pipe.ZAddXX(ctx, outboxPendingKey, redis.Z{Score: float64(retryAt.UnixMilli()), Member: challengeID})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record persistence failure for %s: %w", challengeID, err)
+	}
+	return nil
+}
+
+// QueuePersistJob queues a challenge for persistence now, or requeues a dead-lettered
+// job with its attempts reset
+func (r *RedisRepository) QueuePersistJob(ctx context.Context, challengeID string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, outboxJobKey(challengeID), "attempts", 0)
+		pipe.ZRem(ctx, outboxDeadKey, challengeID)
+		queuePersistJob(ctx, pipe, challengeID, time.Now())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to queue persistence job %s: %w", challengeID, err)
+	}
+	return nil
+}
+
+// ListPersistJobs returns the pending persistence jobs, oldest due first, and the
+// dead-lettered ones, most recent first
+func (r *RedisRepository) ListPersistJobs(ctx context.Context) (pending, dead []model.PersistJob, err error) {
+	var pendingCmd, deadCmd *redis.ZSliceCmd
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pendingCmd = pipe.ZRangeWithScores(ctx, outboxPendingKey, 0, -1)
+		deadCmd = pipe.ZRevRangeWithScores(ctx, outboxDeadKey, 0, -1)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list persistence jobs: %w", err)
+	}
+
+	if pending, err = r.persistJobsFromZ(ctx, pendingCmd.Val(), false); err != nil {
+		return nil, nil, err
+	}
+	if dead, err = r.persistJobsFromZ(ctx, deadCmd.Val(), true); err != nil {
+		return nil, nil, err
+	}
+	return pending, dead, nil
+}
+
+func (r *RedisRepository) persistJobsFromZ(ctx context.Context, entries []redis.Z, dead bool) ([]model.PersistJob, error) {
+	ids := make([]string, len(entries))
+	scores := make(map[string]int64, len(entries))
+	for i, z := range entries {
+		id, _ := z.Member.(string)
+		ids[i] = id
+		scores[id] = int64(z.Score)
+	}
+
+	jobs, err := r.persistJobs(ctx, ids, scores)
+	if err != nil {
+		return nil, err
+	}
+	for i := range jobs {
+		if dead {
+			jobs[i].DeadAt = jobs[i].NextAttemptAt
+			jobs[i].NextAttemptAt = 0
+		}
+	}
+	return jobs, nil
+}
+
+// persistJobs reads the job hashes of the given challenges; scores, if set, fill NextAttemptAt
+func (r *RedisRepository) persistJobs(ctx context.Context, challengeIDs []string, scores map[string]int64) ([]model.PersistJob, error) {
+	cmds := make([]*redis.MapStringStringCmd, len(challengeIDs))
+	if len(challengeIDs) > 0 {
+		_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, id := range challengeIDs {
+				cmds[i] = pipe.HGetAll(ctx, outboxJobKey(id))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read persistence jobs: %w", err)
+		}
+	}
+
+	jobs := make([]model.PersistJob, len(challengeIDs))
+	for i, id := range challengeIDs {
+		fields := cmds[i].Val()
+		attempts, _ := strconv.Atoi(fields["attempts"])
+		enqueuedAt, _ := strconv.ParseInt(fields["enqueuedAt"], 10, 64)
+		updatedAt, _ := strconv.ParseInt(fields["updatedAt"], 10, 64)
+		jobs[i] = model.PersistJob{
+			ChallengeID:   id,
+			Attempts:      attempts,
+			LastError:     fields["lastError"],
+			EnqueuedAt:    enqueuedAt,
+			UpdatedAt:     updatedAt,
+			NextAttemptAt: scores[id],
+		}
+	}
+	return jobs, nil
+}
@@ -0,0 +1,167 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// Presence is kept per challenge in a sorted set of <userID>|<nodeID>|<connectionID>
+// members scored by their last heartbeat (unix seconds). Members older than the TTL are
+// stale and pruned on every write and read, so a crashed node's connections age out.
+
+// connectPresenceScript adds or refreshes a connection and returns 1 if the user had no
+// other live connection in the challenge
+var connectPresenceScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[2])
+local first = 1
+for _, m in ipairs(redis.call('ZRANGE', KEYS[1], 0, -1)) do
+	if m ~= ARGV[3] and string.sub(m, 1, #ARGV[4]) == ARGV[4] then
+		first = 0
+		break
+	end
+end
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[3])
+redis.call('EXPIRE', KEYS[1], ARGV[5])
+return first
+`)
+
+// disconnectPresenceScript removes a connection and returns how many live connections
+// the user still has in the challenge
+var disconnectPresenceScript = redis.NewScript(`
+redis.call('ZREM', KEYS[1], ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local remaining = 0
+for _, m in ipairs(redis.call('ZRANGE', KEYS[1], 0, -1)) do
+	if string.sub(m, 1, #ARGV[3]) == ARGV[3] then
+		remaining = remaining + 1
+	end
+end
+return remaining
+`)
+
+func presenceKey(challengeID string) string {
+	return fmt.Sprintf("challenge_presence:%s", challengeID)
+}
+
+func presenceMember(userID, nodeID, connectionID string) string {
+	return userID + "|" + nodeID + "|" + connectionID
+}
+
+// ConnectPresence records a live connection of a user and reports whether it is the
+// user's first live connection to the challenge on any node
+func (r *RedisRepository) ConnectPresence(ctx context.Context, challengeID, userID, nodeID, connectionID string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	first, err := connectPresenceScript.Run(ctx, r.client, []string{presenceKey(challengeID)},
+		now.Unix(), now.Add(-ttl).Unix(), presenceMember(userID, nodeID, connectionID), userID+"|", int64(ttl/time.Second)*2,
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to record presence: %w", err)
+	}
+	return first == 1, nil
+}
+
+// DisconnectPresence removes a connection and returns how many live connections the
+// user still has in the challenge across all nodes
+func (r *RedisRepository) DisconnectPresence(ctx context.Context, challengeID, userID, nodeID, connectionID string, ttl time.Duration) (int, error) {
+	remaining, err := disconnectPresenceScript.Run(ctx, r.client, []string{presenceKey(challengeID)},
+		time.Now().Add(-ttl).Unix(), presenceMember(userID, nodeID, connectionID), userID+"|",
+	).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove presence: %w", err)
+	}
+	return remaining, nil
+}
+
+// PresenceConnection identifies a live connection for RefreshPresence
+type PresenceConnection struct {
+	ChallengeID  string
+	UserID       string
+	ConnectionID string
+}
+
+// RefreshPresence heartbeats a node's live connections in one round trip
+func (r *RedisRepository) RefreshPresence(ctx context.Context, nodeID string, conns []PresenceConnection, ttl time.Duration) error {
+	if len(conns) == 0 {
+		return nil
+	}
+
+	now := float64(time.Now().Unix())
+	pipe := r.client.Pipeline()
+	refreshed := make(map[string]bool)
+	for _, c := range conns {
+		key := presenceKey(c.ChallengeID)
+		pipe.ZAdd(ctx, key, redis.Z{Score: now, Member: presenceMember(c.UserID, nodeID, c.ConnectionID)})
+		if !refreshed[key] {
+			pipe.Expire(ctx, key, ttl*2)
+			refreshed[key] = true
+		}
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to refresh presence: %w", err)
+	}
+	return nil
+}
+
+// GetChallengePresence returns the users with live connections to a challenge, sorted by user ID
+func (r *RedisRepository) GetChallengePresence(ctx context.Context, challengeID string, ttl time.Duration) ([]model.UserPresence, error) {
+	key := presenceKey(challengeID)
+	cutoff := fmt.Sprintf("(%d", time.Now().Add(-ttl).Unix())
+
+	members, err := r.client.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: cutoff, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get presence: %w", err)
+	}
+
+	byUser := make(map[string]*model.UserPresence)
+	for _, z := range members {
+		member, _ := z.Member.(string)
+		parts := strings.SplitN(member, "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		heartbeat := int64(z.Score)
+		user, ok := byUser[parts[0]]
+		if !ok {
+			user = &model.UserPresence{UserID: parts[0]}
+			byUser[parts[0]] = user
+		}
+		user.Connections = append(user.Connections, model.ConnectionPresence{
+			ConnectionID:  parts[2],
+			NodeID:        parts[1],
+			LastHeartbeat: heartbeat,
+		})
+		if heartbeat > user.LastHeartbeat {
+			user.LastHeartbeat = heartbeat
+		}
+	}
+
+	users := make([]model.UserPresence, 0, len(byUser))
+	for _, user := range byUser {
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserID < users[j].UserID })
+
+	return users, nil
+}
+
+// IsUserPresent reports whether a user has a live connection to a challenge on any node
+func (r *RedisRepository) IsUserPresent(ctx context.Context, challengeID, userID string, ttl time.Duration) (bool, error) {
+	users, err := r.GetChallengePresence(ctx, challengeID, ttl)
+	if err != nil {
+		return false, err
+	}
+	for _, user := range users {
+		if user.UserID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
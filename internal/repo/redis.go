@@ -2,33 +2,47 @@ package repo
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
 	"github.com/redis/go-redis/v9"
 )
 
 type RedisRepository struct {
-	client *redis.Client
+	client            *redis.Client
+	eventStreamMaxLen int64
+	txMaxAttempts     int
+	expiryGrace       time.Duration
 }
 
 func NewRedisRepository(client *redis.Client) *RedisRepository {
 	return &RedisRepository{
-		client: client,
+		client:            client,
+		eventStreamMaxLen: DefaultEventStreamMaxLen,
+		txMaxAttempts:     DefaultTxMaxAttempts,
+		expiryGrace:       DefaultExpiryGrace,
 	}
 }
 
 // CreateChallenge stores a new challenge in Redis
 func (r *RedisRepository) CreateChallenge(ctx context.Context, challenge *model.ChallengeDocument) error {
-	key := fmt.Sprintf("challenge:%s", challenge.ChallengeID)
-
-	data, err := json.Marshal(challenge)
+	layout, err := encodeChallenge(challenge)
 	if err != nil {
-		return fmt.Errorf("failed to marshal challenge: %w", err)
+		return err
 	}
 
-	return r.client.Set(ctx, key, data, 0).Err()
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, challengeKey(challenge.ChallengeID), participantsKey(challenge.ChallengeID))
+		writeChallengeDiff(ctx, pipe, challenge.ChallengeID, challengeLayout{}, layout)
+		r.writeExpiry(ctx, pipe, challenge.ChallengeID, challenge, layout, challengeKeys(challenge.ChallengeID, layout))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store challenge: %w", err)
+	}
+	return nil
 }
 
 // GetChallenge retrieves a challenge from Redis
@@ -40,86 +54,85 @@ func (r *RedisRepository) GetChallenge(ctx context.Context, challengeID string)
 	return &challengeDoc, nil
 }
 
-// UpdateChallenge updates an existing challenge in Redis
+// UpdateChallenge replaces a challenge read earlier, provided nobody has written it since:
+// a challenge whose Version no longer matches the stored one fails with ErrChallengeConflict.
+// On success challenge.Version is bumped. Prefer MutateChallenge, which retries on conflict.
 func (r *RedisRepository) UpdateChallenge(ctx context.Context, challenge *model.ChallengeDocument) error {
-	return r.CreateChallenge(ctx, challenge) // Same as create for Redis
-}
-
-// DeleteChallenge removes a challenge from Redis
-func (r *RedisRepository) DeleteChallenge(ctx context.Context, challengeID string) error {
-	key := fmt.Sprintf("challenge:%s", challengeID)
-	return r.client.Del(ctx, key).Err()
-}
-
-// GetActiveChallenges returns all challenge IDs from Redis
-func (r *RedisRepository) GetActiveChallenges(ctx context.Context) ([]string, error) {
-	keys, err := r.client.Keys(ctx, "challenge:*").Result()
+	updated, err := r.MutateChallenge(ctx, challenge.ChallengeID, func(stored *model.ChallengeDocument) error {
+		if stored.Version != challenge.Version {
+			return fmt.Errorf("%w: challenge %s is at version %d, not %d", ErrChallengeConflict, challenge.ChallengeID, stored.Version, challenge.Version)
+		}
+		*stored = *challenge
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get challenge keys: %w", err)
-	}
-
-	challengeIDs := make([]string, len(keys))
-	for i, key := range keys {
-		// Extract challenge ID from key (remove "challenge:" prefix)
-		challengeIDs[i] = key[10:]
+		return err
 	}
 
-	return challengeIDs, nil
+	challenge.Version = updated.Version
+	return nil
 }
 
-// GetChallengesByStatus returns challenge IDs filtered by status
-func (r *RedisRepository) GetChallengesByStatus(ctx context.Context, status string) ([]string, error) {
-	challengeIDs, err := r.GetActiveChallenges(ctx)
-	if err != nil {
-		return nil, err
+// DeleteChallenge removes a challenge, its submissions, index entries, expiry marker and event stream from Redis
+func (r *RedisRepository) DeleteChallenge(ctx context.Context, challengeID string) error {
+	var layout challengeLayout
+	err := r.withLegacyMigration(ctx, challengeID, func() (err error) {
+		layout, err = loadChallenge(ctx, r.client, challengeID)
+		return err
+	})
+	if errors.Is(err, ErrChallengeNotFound) {
+		if err := r.dropFromIndexes(ctx, challengeID); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
 	}
 
-	var filteredIDs []string
-	for _, id := range challengeIDs {
-		challenge, err := r.GetChallenge(ctx, id)
-		if err != nil {
-			continue // Skip challenges that can't be retrieved
-		}
+	keys := append(challengeKeys(challengeID, layout), eventSeqKey(challengeID), eventStreamKey(challengeID), presenceKey(challengeID), expiryKey(challengeID))
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keys...)
+		writeIndexDiff(ctx, pipe, challengeID, layout.meta, nil)
+		return nil
+	})
+	return err
+}
 
-		if string(challenge.Status) == status {
-			filteredIDs = append(filteredIDs, id)
-		}
-	}
+// GetActiveChallenges returns all challenge IDs from Redis, newest first
+func (r *RedisRepository) GetActiveChallenges(ctx context.Context) ([]string, error) {
+	return r.indexedChallengeIDs(ctx, allIndexKey)
+}
 
-	return filteredIDs, nil
+// GetChallengesByStatus returns challenge IDs filtered by status, newest first
+func (r *RedisRepository) GetChallengesByStatus(ctx context.Context, status string) ([]string, error) {
+	return r.indexedChallengeIDs(ctx, statusIndexKey(status))
 }
 
 // AddParticipant adds a participant to a challenge
 func (r *RedisRepository) AddParticipant(ctx context.Context, challengeID, userID string, metadata *model.ParticipantMetadata) error {
-	challenge, err := r.GetChallenge(ctx, challengeID)
-	if err != nil {
-		return err
-	}
-
-	if challenge.Participants == nil {
-		challenge.Participants = make(map[string]*model.ParticipantMetadata)
-	}
+	_, err := r.MutateChallenge(ctx, challengeID, func(challenge *model.ChallengeDocument) error {
+		if challenge.Participants == nil {
+			challenge.Participants = make(map[string]*model.ParticipantMetadata)
+		}
 
-	challenge.Participants[userID] = metadata
-	return r.UpdateChallenge(ctx, challenge)
+		challenge.Participants[userID] = metadata
+		return nil
+	})
+	return err
 }
 
 // RemoveParticipant removes a participant from a challenge
 func (r *RedisRepository) RemoveParticipant(ctx context.Context, challengeID, userID string) error {
-	challenge, err := r.GetChallenge(ctx, challengeID)
-	if err != nil {
-		return err
-	}
-
-	if challenge.Participants != nil {
-		delete(challenge.Participants, userID)
-	}
-
-	if challenge.Submissions != nil {
-		delete(challenge.Submissions, userID)
-	}
+	_, err := r.MutateChallenge(ctx, challengeID, func(challenge *model.ChallengeDocument) error {
+		if challenge.Participants != nil {
+			delete(challenge.Participants, userID)
+		}
 
-	return r.UpdateChallenge(ctx, challenge)
+		if challenge.Submissions != nil {
+			delete(challenge.Submissions, userID)
+		}
+		return nil
+	})
+	return err
 }
 
 // UpdateParticipant updates participant metadata
@@ -129,39 +142,31 @@ func (r *RedisRepository) UpdateParticipant(ctx context.Context, challengeID, us
 
 // GetChallengeByID retrieves a challenge document from Redis
 func (r *RedisRepository) GetChallengeByID(ctx context.Context, challengeID string) (model.ChallengeDocument, error) {
-	key := fmt.Sprintf("challenge:%s", challengeID)
-
-	data, err := r.client.Get(ctx, key).Result()
+	var layout challengeLayout
+	err := r.withLegacyMigration(ctx, challengeID, func() (err error) {
+		layout, err = loadChallenge(ctx, r.client, challengeID)
+		return err
+	})
 	if err != nil {
-		if err == redis.Nil {
-			return model.ChallengeDocument{}, fmt.Errorf("challenge not found")
-		}
-		return model.ChallengeDocument{}, fmt.Errorf("failed to get challenge: %w", err)
-	}
-
-	var challengeDoc model.ChallengeDocument
-	if err := json.Unmarshal([]byte(data), &challengeDoc); err != nil {
-		return model.ChallengeDocument{}, fmt.Errorf("failed to unmarshal challenge: %w", err)
+		return model.ChallengeDocument{}, err
 	}
 
-	return challengeDoc, nil
+	return decodeChallenge(layout)
 }
 
 // AbandonChallenge updates challenge status to ABANDON in Redis
 func (r *RedisRepository) AbandonChallenge(ctx context.Context, creatorID, challengeID string) error {
-	challenge, err := r.GetChallenge(ctx, challengeID)
-	if err != nil {
-		return err
-	}
-
-	// Verify the creator
-	if challenge.CreatorID != creatorID {
-		return fmt.Errorf("only the creator can abandon the challenge")
-	}
+	_, err := r.MutateChallenge(ctx, challengeID, func(challenge *model.ChallengeDocument) error {
+		// Verify the creator
+		if challenge.CreatorID != creatorID {
+			return fmt.Errorf("only the creator can abandon the challenge")
+		}
 
-	// Update status to ABANDON
-	challenge.Status = model.ChallengeAbandon
-	return r.UpdateChallenge(ctx, challenge)
+		// Update status to ABANDON
+		challenge.Status = model.ChallengeAbandon
+		return nil
+	})
+	return err
 }
 
 // RemoveParticipantInJoinPhase removes a participant during join phase
@@ -9,11 +9,18 @@ import (
 
 	"github.com/lijuuu/ChallengeWssManagerService/internal/global"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/repo"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/utils"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/broadcasts"
 	challengePb "github.com/lijuuu/GlobalProtoXcode/ChallengeService"
 )
 
+// errNotParticipant aborts a submission update for a user who never joined the challenge
+var errNotParticipant = errors.New("user not a participant")
+
+// errOpenChallengeExists rejects creating a challenge while another one is open
+var errOpenChallengeExists = errors.New("active challenge already found, can't create new challenge")
+
 type ChallengeService struct {
 	GlobalState *global.State
 	challengePb.UnimplementedChallengeServiceServer
@@ -26,16 +33,30 @@ func NewChallengeService(GlobalState *global.State) *ChallengeService {
 }
 
 func (s *ChallengeService) CreateChallenge(ctx context.Context, req *challengePb.ChallengeRecord) (*challengePb.ChallengeRecord, error) {
+	if _, err := s.createChallenge(ctx, req); err != nil {
+		return nil, err
+	}
 
-	// Check for active challenges using Redis
-	challengeIDs, err := s.GlobalState.Redis.GetChallengesByStatus(ctx, string(model.ChallengeOpen))
+	return req, nil
+}
+
+// createChallenge stores a challenge a user asked for, allowing only one open challenge
+// at a time. Challenges the service creates for matches and rematches skip the check.
+func (s *ChallengeService) createChallenge(ctx context.Context, req *challengePb.ChallengeRecord) (*model.ChallengeDocument, error) {
+	// Check for active challenges using the Redis status index
+	openCount, err := s.GlobalState.Redis.CountChallenges(ctx, repo.ChallengeFilter{Status: model.ChallengeOpen})
 	if err != nil {
 		return nil, err
 	}
-	if len(challengeIDs) != 0 {
-		return nil, errors.New("active challenge already found, can't create new challenge")
+	if openCount != 0 {
+		return nil, errOpenChallengeExists
 	}
 
+	return s.createChallengeRecord(ctx, req)
+}
+
+// createChallengeRecord stores a new open challenge in Redis and initializes its leaderboard
+func (s *ChallengeService) createChallengeRecord(ctx context.Context, req *challengePb.ChallengeRecord) (*model.ChallengeDocument, error) {
 	modelChallengeDoc := ChallengeDocumentFromProto(req, false)
 
 	// Initialize challenge document for Redis storage
@@ -82,7 +103,7 @@ func (s *ChallengeService) CreateChallenge(ctx context.Context, req *challengePb
 		}
 	}
 
-	return req, nil
+	return modelChallengeDoc, nil
 }
 
 func (s *ChallengeService) LeaveChallenge(ctx context.Context, challengeId, userId string) bool {
@@ -130,34 +151,32 @@ func (s *ChallengeService) AbandonChallenge(ctx context.Context, req *challengeP
 		}, err
 	}
 
-	// Clean up leaderboard for abandoned challenge
-	if err := s.GlobalState.LeaderboardManager.CleanupLeaderboard(req.ChallengeId); err != nil {
+	// Snapshot final standings before the leaderboard is closed
+	if _, err := s.snapshotFinalLeaderboard(ctx, req.ChallengeId); err != nil {
+		log.Printf("[AbandonChallenge] Warning: Failed to snapshot leaderboard for challenge %s: %v", req.ChallengeId, err)
+	}
+
+	// Clean up leaderboard and its Redis keys for abandoned challenge
+	if err := s.GlobalState.LeaderboardManager.DeleteLeaderboard(ctx, req.ChallengeId); err != nil {
 		log.Printf("[AbandonChallenge] Warning: Failed to cleanup leaderboard for challenge %s: %v", req.ChallengeId, err)
 	}
 
 	// Trigger MongoDB persistence for ABANDONED challenge
 	if err := s.persistChallengeToMongoDB(ctx, req.ChallengeId); err != nil {
-		// Log the error but don't fail the abandon operation
+		// Log the error but don't fail the abandon operation; the outbox retries it
 		fmt.Printf("Warning: Failed to persist abandoned challenge %s to MongoDB: %v\n", req.ChallengeId, err)
 	}
 
-	// Check for nil websocketState or LocalState
-	if s.GlobalState == nil || s.GlobalState.LocalState == nil {
+	// Check for nil websocketState or Broadcaster
+	if s.GlobalState == nil || s.GlobalState.Broadcaster == nil {
 		// Log the issue (consider adding a proper logger instead of fmt)
-		fmt.Printf("Warning: websocketState or LocalState is nil for challenge ID %s\n", req.ChallengeId)
-		return &challengePb.AbandonChallengeResponse{Success: true}, nil
-	}
-
-	// Get WebSocket clients for broadcasting
-	wsClients := s.GlobalState.LocalState.GetAllWSClients(challenge.ChallengeID)
-	if len(wsClients) == 0 {
-		// Log the issue
-		fmt.Printf("Warning: No WebSocket clients found for challenge ID %s\n", challenge.ChallengeID)
+		fmt.Printf("Warning: websocketState or Broadcaster is nil for challenge ID %s\n", req.ChallengeId)
 		return &challengePb.AbandonChallengeResponse{Success: true}, nil
 	}
 
-	// Broadcast the abandon event using the new method with clients
-	broadcasts.BroadcastChallengeAbandonWithClients(wsClients, challenge.ChallengeID, challenge.CreatorID)
+	// Broadcast the abandon event to every node's clients. The challenge's event stream
+	// was deleted with its Redis data, so this final event is not sequenced
+	broadcasts.BroadcastChallengeAbandon(s.GlobalState.Broadcaster, nil, challenge.ChallengeID, challenge.CreatorID)
 
 	return &challengePb.AbandonChallengeResponse{Success: true}, nil
 }
@@ -186,74 +205,61 @@ func (s *ChallengeService) GetChallengeHistory(ctx context.Context, req *challen
 }
 
 func (s *ChallengeService) GetActiveOpenChallenges(ctx context.Context, req *challengePb.PaginationRequest) (*challengePb.ChallengeListResponse, error) {
-	// For active challenges, use Redis repository only
-	challengeIDs, err := s.GlobalState.Redis.GetChallengesByStatus(ctx, string(model.ChallengeOpen))
+	// For active challenges, use the Redis status index only
+	challenges, total, err := s.listIndexedChallenges(ctx, repo.ChallengeFilter{Status: model.ChallengeOpen}, int(req.Page)*int(req.PageSize), int(req.PageSize))
 	if err != nil {
 		return nil, err
 	}
 
-	// Get challenge documents from Redis
-	var challenges []model.ChallengeDocument
-	for _, id := range challengeIDs {
-		challenge, err := s.GlobalState.Redis.GetChallengeByID(ctx, id)
-		if err != nil {
-			continue // Skip challenges that can't be retrieved
-		}
-		challenges = append(challenges, challenge)
-	}
-
-	// Apply pagination
-	start := int(req.Page) * int(req.PageSize)
-	end := start + int(req.PageSize)
-	if start > len(challenges) {
-		challenges = []model.ChallengeDocument{}
-	} else if end > len(challenges) {
-		challenges = challenges[start:]
-	} else {
-		challenges = challenges[start:end]
-	}
-
 	return &challengePb.ChallengeListResponse{
 		Challenges: ChallengesToProto(toPtrSlice(challenges), true),
-		TotalCount: int64(len(challenges)),
+		TotalCount: total,
 	}, nil
 }
 
 func (s *ChallengeService) GetOwnersActiveChallenges(ctx context.Context, req *challengePb.GetOwnersActiveChallengesRequest) (*challengePb.ChallengeListResponse, error) {
-	// For active challenges owned by a user, use Redis repository only
-	challengeIDs, err := s.GlobalState.Redis.GetActiveChallenges(ctx)
+	// For active challenges owned by a user, use the Redis creator index only
+	challenges, total, err := s.listIndexedChallenges(ctx, repo.ChallengeFilter{CreatorID: req.UserId}, int(req.GetPagination().GetPage())*int(req.GetPagination().GetPageSize()), int(req.GetPagination().GetPageSize()))
 	if err != nil {
 		return nil, err
 	}
 
-	// Filter challenges by owner and get challenge documents from Redis
-	var challenges []model.ChallengeDocument
+	return &challengePb.ChallengeListResponse{
+		Challenges: ChallengesToProto(toPtrSlice(challenges), false),
+		TotalCount: total,
+	}, nil
+}
+
+// listIndexedChallenges loads one offset page of the challenges matching filter
+func (s *ChallengeService) listIndexedChallenges(ctx context.Context, filter repo.ChallengeFilter, offset, limit int) ([]model.ChallengeDocument, int64, error) {
+	page, err := s.GlobalState.Redis.ListChallengeIDs(ctx, filter, "", offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.loadChallenges(ctx, page.ChallengeIDs), page.Total, nil
+}
+
+// ListChallenges returns a cursor page of the active challenges matching filter, newest first.
+// Pass the returned cursor back to get the next page; it is empty after the last one.
+func (s *ChallengeService) ListChallenges(ctx context.Context, filter repo.ChallengeFilter, cursor string, limit int) ([]model.ChallengeDocument, string, int64, error) {
+	page, err := s.GlobalState.Redis.ListChallengeIDs(ctx, filter, cursor, 0, limit)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return s.loadChallenges(ctx, page.ChallengeIDs), page.NextCursor, page.Total, nil
+}
+
+// loadChallenges fetches challenge documents from Redis in order, skipping any deleted meanwhile
+func (s *ChallengeService) loadChallenges(ctx context.Context, challengeIDs []string) []model.ChallengeDocument {
+	challenges := make([]model.ChallengeDocument, 0, len(challengeIDs))
 	for _, id := range challengeIDs {
 		challenge, err := s.GlobalState.Redis.GetChallengeByID(ctx, id)
 		if err != nil {
 			continue // Skip challenges that can't be retrieved
 		}
-		// Only include challenges owned by the requesting user
-		if challenge.CreatorID == req.UserId {
-			challenges = append(challenges, challenge)
-		}
-	}
-
-	// Apply pagination
-	start := int(req.Pagination.Page) * int(req.Pagination.PageSize)
-	end := start + int(req.Pagination.PageSize)
-	if start > len(challenges) {
-		challenges = []model.ChallengeDocument{}
-	} else if end > len(challenges) {
-		challenges = challenges[start:]
-	} else {
-		challenges = challenges[start:end]
+		challenges = append(challenges, challenge)
 	}
-
-	return &challengePb.ChallengeListResponse{
-		Challenges: ChallengesToProto(toPtrSlice(challenges), false),
-		TotalCount: int64(len(challenges)),
-	}, nil
+	return challenges
 }
 
 func (s *ChallengeService) PushSubmissionStatus(ctx context.Context, req *challengePb.PushSubmissionStatusRequest) (*challengePb.PushSubmissionStatusResponse, error) {
@@ -275,64 +281,65 @@ func (s *ChallengeService) PushSubmissionStatus(ctx context.Context, req *challe
 		return &challengePb.PushSubmissionStatusResponse{Message: "received unsuccessful submission", Success: true}, nil
 	}
 
-	// Get challenge from Redis to verify it exists and is active
-	challenge, err := s.GlobalState.Redis.GetChallengeByID(ctx, challengeID)
-	if err != nil {
-		log.Printf("[PushSubmissionStatus] Challenge not found: %v", err)
-		return &challengePb.PushSubmissionStatusResponse{Message: "challenge not found", Success: false}, err
-	}
-
-	// Verify user is a participant
-	participant, exists := challenge.Participants[userID]
-	if !exists {
-		log.Printf("[PushSubmissionStatus] User %s not a participant in challenge %s", userID, challengeID)
-		return &challengePb.PushSubmissionStatusResponse{Message: "user not a participant", Success: false}, nil
-	}
+	// Record the submission and the participant's new score in one optimistic transaction,
+	// so concurrent submissions and joins can't overwrite each other
+	var totalScore int
+	challenge, err := s.GlobalState.Redis.MutateChallenge(ctx, challengeID, func(challenge *model.ChallengeDocument) error {
+		// Verify user is a participant
+		participant, exists := challenge.Participants[userID]
+		if !exists {
+			return errNotParticipant
+		}
 
-	// Update submission data in Redis
-	submission := model.Submission{
-		SubmissionID: submissionID,
-		TimeTaken:    timeTaken,
-		Points:       score,
-	}
+		// The first accepted submission starts the challenge, so it ends with final
+		// standings rather than being abandoned when it expires
+		if challenge.Status == model.ChallengeOpen {
+			challenge.Status = model.ChallengeStarted
+		}
 
-	// Initialize submissions map if needed
-	if challenge.Submissions == nil {
-		challenge.Submissions = make(map[string]map[string]model.Submission)
-	}
-	if challenge.Submissions[userID] == nil {
-		challenge.Submissions[userID] = make(map[string]model.Submission)
-	}
+		// Initialize submissions map if needed
+		if challenge.Submissions == nil {
+			challenge.Submissions = make(map[string]map[string]model.Submission)
+		}
+		if challenge.Submissions[userID] == nil {
+			challenge.Submissions[userID] = make(map[string]model.Submission)
+		}
 
-	// Store the submission
-	challenge.Submissions[userID][problemID] = submission
+		// Store the submission
+		challenge.Submissions[userID][problemID] = model.Submission{
+			SubmissionID: submissionID,
+			TimeTaken:    timeTaken,
+			Points:       score,
+		}
 
-	// Update participant metadata
-	if participant.ProblemsDone == nil {
-		participant.ProblemsDone = make(map[string]model.ChallengeProblemMetadata)
-	}
-	participant.ProblemsDone[problemID] = model.ChallengeProblemMetadata{
-		Score:     score,
-		TimeTaken: int64(timeTaken),
-	}
+		// Update participant metadata
+		if participant.ProblemsDone == nil {
+			participant.ProblemsDone = make(map[string]model.ChallengeProblemMetadata)
+		}
+		participant.ProblemsDone[problemID] = model.ChallengeProblemMetadata{
+			ProblemID:   problemID,
+			Score:       score,
+			TimeTaken:   int64(timeTaken),
+			CompletedAt: time.Now().Unix(),
+		}
 
-	// Calculate new total score for the participant
-	totalScore := 0
-	for _, problemMeta := range participant.ProblemsDone {
-		totalScore += problemMeta.Score
+		// Calculate new total score for the participant
+		totalScore = 0
+		for _, problemMeta := range participant.ProblemsDone {
+			totalScore += problemMeta.Score
+		}
+		participant.TotalScore = totalScore
+		participant.ProblemsAttempted = len(participant.ProblemsDone)
+		return nil
+	})
+	if errors.Is(err, repo.ErrChallengeNotFound) {
+		log.Printf("[PushSubmissionStatus] Challenge not found: %v", err)
+		return &challengePb.PushSubmissionStatusResponse{Message: "challenge not found", Success: false}, err
 	}
-	participant.TotalScore = totalScore
-	participant.ProblemsAttempted = len(participant.ProblemsDone)
-
-	// Update participant in Redis
-	err = s.GlobalState.Redis.UpdateParticipant(ctx, challengeID, userID, participant)
-	if err != nil {
-		log.Printf("[PushSubmissionStatus] Failed to update participant: %v", err)
-		return &challengePb.PushSubmissionStatusResponse{Message: "failed to update participant", Success: false}, err
+	if errors.Is(err, errNotParticipant) {
+		log.Printf("[PushSubmissionStatus] User %s not a participant in challenge %s", userID, challengeID)
+		return &challengePb.PushSubmissionStatusResponse{Message: "user not a participant", Success: false}, nil
 	}
-
-	// Update challenge in Redis
-	err = s.GlobalState.Redis.UpdateChallenge(ctx, &challenge)
 	if err != nil {
 		log.Printf("[PushSubmissionStatus] Failed to update challenge: %v", err)
 		return &challengePb.PushSubmissionStatusResponse{Message: "failed to update challenge", Success: false}, err
@@ -357,7 +364,7 @@ func (s *ChallengeService) PushSubmissionStatus(ctx context.Context, req *challe
 	var newRank int = -1
 
 	// Get updated leaderboard data
-	leaderboard, err = s.GlobalState.LeaderboardManager.GetLeaderboard(challengeID, 50, &challenge) // Get top 50
+	leaderboard, err = s.GlobalState.LeaderboardManager.GetLeaderboard(challengeID, 50, challenge) // Get top 50
 	if err != nil {
 		log.Printf("[PushSubmissionStatus] Failed to get leaderboard: %v", err)
 	}
@@ -371,15 +378,13 @@ func (s *ChallengeService) PushSubmissionStatus(ctx context.Context, req *challe
 	}
 
 	// Broadcast events to WebSocket clients
-	if s.GlobalState != nil && s.GlobalState.LocalState != nil {
-		wsClients := s.GlobalState.LocalState.GetAllWSClients(challengeID)
-
+	if s.GlobalState != nil && s.GlobalState.Broadcaster != nil {
 		// Broadcast NEW_SUBMISSION event
-		broadcasts.BroadcastNewSubmission(wsClients, challengeID, userID, problemID, score, newRank)
+		broadcasts.BroadcastNewSubmission(s.GlobalState.Broadcaster, s.GlobalState.Redis, challengeID, userID, problemID, score, newRank)
 
 		// Broadcast LEADERBOARD_UPDATE event if we have leaderboard data
 		if leaderboard != nil {
-			broadcasts.BroadcastLeaderboardUpdate(wsClients, challengeID, leaderboard, userID)
+			broadcasts.BroadcastLeaderboardUpdate(s.GlobalState.Broadcaster, s.GlobalState.Redis, challengeID, leaderboard, userID)
 		}
 	}
 
@@ -389,26 +394,47 @@ func (s *ChallengeService) PushSubmissionStatus(ctx context.Context, req *challe
 	return &challengePb.PushSubmissionStatusResponse{Message: "submission processed successfully", Success: true}, nil
 }
 
-// EndChallenge ends a challenge and triggers MongoDB persistence
+// EndChallenge ends an open or started challenge and triggers MongoDB persistence. The
+// status and final standings are stored in one update, so a challenge is ended once and
+// its leaderboard is only deleted after the standings are safe.
 func (s *ChallengeService) EndChallenge(ctx context.Context, challengeID, creatorID string) error {
-	// Fetch the challenge to verify the creator using Redis repository
-	challenge, err := s.GlobalState.Redis.GetChallengeByID(ctx, challengeID)
-	if err != nil {
+	challenge, err := s.GlobalState.Redis.MutateChallenge(ctx, challengeID, func(challenge *model.ChallengeDocument) error {
+		if challenge.CreatorID != creatorID {
+			return errors.New("only the creator can end the challenge")
+		}
+		if challenge.Status != model.ChallengeOpen && challenge.Status != model.ChallengeStarted {
+			return fmt.Errorf("challenge is %s and cannot be ended", challenge.Status)
+		}
+
+		challenge.Status = model.ChallengeEnded
+		challenge.Leaderboard = s.GlobalState.LeaderboardManager.GetFinalLeaderboard(challengeID, challenge)
+		return nil
+	})
+	if errors.Is(err, repo.ErrChallengeNotFound) {
 		return fmt.Errorf("challenge not found: %w", err)
 	}
+	if err != nil {
+		return fmt.Errorf("failed to end challenge: %w", err)
+	}
 
-	if challenge.CreatorID != creatorID {
-		return errors.New("only the creator can end the challenge")
+	// Update participant ratings from the final standings
+	if err := s.updateRatings(ctx, challengeID, challenge.Leaderboard); err != nil {
+		log.Printf("[EndChallenge] Warning: Failed to update ratings for challenge %s: %v", challengeID, err)
 	}
 
-	// Clean up leaderboard for ended challenge
-	if err := s.GlobalState.LeaderboardManager.CleanupLeaderboard(challengeID); err != nil {
-		log.Printf("[EndChallenge] Warning: Failed to cleanup leaderboard for challenge %s: %v", challengeID, err)
+	// Aggregate results into the all-time and seasonal leaderboards
+	if err := s.updateGlobalLeaderboards(ctx, challengeID, challenge.Leaderboard); err != nil {
+		log.Printf("[EndChallenge] Warning: Failed to update global leaderboards for challenge %s: %v", challengeID, err)
 	}
 
-	// Update challenge status to ENDED using the helper method that triggers persistence
-	if err := s.updateChallengeStatus(ctx, challengeID, model.ChallengeEnded); err != nil {
-		return fmt.Errorf("failed to end challenge: %w", err)
+	// The status change queued persistence in the outbox, which retries it if this fails
+	if err := s.persistChallengeToMongoDB(ctx, challengeID); err != nil {
+		log.Printf("[EndChallenge] Warning: Failed to persist challenge %s to MongoDB: %v", challengeID, err)
+	}
+
+	// Clean up leaderboard and its Redis keys for ended challenge
+	if err := s.GlobalState.LeaderboardManager.DeleteLeaderboard(ctx, challengeID); err != nil {
+		log.Printf("[EndChallenge] Warning: Failed to cleanup leaderboard for challenge %s: %v", challengeID, err)
 	}
 
 	return nil
@@ -544,10 +570,34 @@ func toPtrSlice(in []model.ChallengeDocument) []*model.ChallengeDocument {
 	return out
 }
 
-// persistChallengeToMongoDB transfers challenge data from Redis to MongoDB and cleans up Redis
-func (s *ChallengeService) persistChallengeToMongoDB(ctx context.Context, challengeID string) error {
+// snapshotFinalLeaderboard stores the final ranked standings in the Redis challenge document
+// so they are carried into MongoDB by persistChallengeToMongoDB
+func (s *ChallengeService) snapshotFinalLeaderboard(ctx context.Context, challengeID string) ([]*model.LeaderboardEntry, error) {
+	var leaderboard []*model.LeaderboardEntry
+	_, err := s.GlobalState.Redis.MutateChallenge(ctx, challengeID, func(challenge *model.ChallengeDocument) error {
+		leaderboard = s.GlobalState.LeaderboardManager.GetFinalLeaderboard(challengeID, challenge)
+		challenge.Leaderboard = leaderboard
+		return nil
+	})
+	if errors.Is(err, repo.ErrChallengeNotFound) {
+		return nil, fmt.Errorf("failed to get challenge: %w", err)
+	}
+	if err != nil {
+		return leaderboard, fmt.Errorf("failed to store leaderboard snapshot: %w", err)
+	}
+
+	return leaderboard, nil
+}
+
+// PersistChallenge transfers challenge data from Redis to MongoDB and cleans up Redis.
+// It runs the challenge's persistence outbox job, so it is safe to repeat: a challenge
+// already gone from Redis was persisted by an earlier run.
+func (s *ChallengeService) PersistChallenge(ctx context.Context, challengeID string) error {
 	// Get challenge data from Redis
 	challengeDoc, err := s.GlobalState.Redis.GetChallengeByID(ctx, challengeID)
+	if errors.Is(err, repo.ErrChallengeNotFound) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get challenge from Redis: %w", err)
 	}
@@ -566,24 +616,33 @@ func (s *ChallengeService) persistChallengeToMongoDB(ctx context.Context, challe
 	return nil
 }
 
+// persistChallengeToMongoDB runs a finished challenge's queued persistence job now, so
+// it is in MongoDB when the caller returns. On failure the job stays queued for retry.
+func (s *ChallengeService) persistChallengeToMongoDB(ctx context.Context, challengeID string) error {
+	if s.GlobalState.Outbox == nil {
+		return s.PersistChallenge(ctx, challengeID)
+	}
+	return s.GlobalState.Outbox.Process(ctx, challengeID)
+}
+
 // updateChallengeStatus updates challenge status and triggers persistence if needed
 func (s *ChallengeService) updateChallengeStatus(ctx context.Context, challengeID string, newStatus string) error {
-	// Get current challenge from Redis
-	challenge, err := s.GlobalState.Redis.GetChallenge(ctx, challengeID)
-	if err != nil {
+	// Update status
+	_, err := s.GlobalState.Redis.MutateChallenge(ctx, challengeID, func(challenge *model.ChallengeDocument) error {
+		challenge.Status = newStatus
+		return nil
+	})
+	if errors.Is(err, repo.ErrChallengeNotFound) {
 		return fmt.Errorf("failed to get challenge: %w", err)
 	}
-
-	// Update status
-	challenge.Status = newStatus
-	if err := s.GlobalState.Redis.UpdateChallenge(ctx, challenge); err != nil {
+	if err != nil {
 		return fmt.Errorf("failed to update challenge status: %w", err)
 	}
 
 	// Check if we need to trigger MongoDB persistence
 	if newStatus == model.ChallengeAbandon || newStatus == model.ChallengeEnded {
 		if err := s.persistChallengeToMongoDB(ctx, challengeID); err != nil {
-			// Log the error but don't fail the status update
+			// Log the error but don't fail the status update; the outbox retries it
 			fmt.Printf("Warning: Failed to persist challenge %s to MongoDB after status change to %s: %v\n", challengeID, newStatus, err)
 		}
 	}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/repo"
+)
+
+// RunExpiryListener persists and cleans up challenges as their Redis TTL runs out, until
+// ctx is cancelled. Every node may run it; each expired challenge is handled by one.
+func (s *ChallengeService) RunExpiryListener(ctx context.Context) {
+	err := s.GlobalState.Redis.WatchChallengeExpiry(ctx, func(challengeID string) {
+		s.handleChallengeExpired(ctx, challengeID)
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("[Expiry] Listener stopped: %v", err)
+	}
+}
+
+// handleChallengeExpired closes out a challenge that saw no writes for its time limit plus
+// the buffer: an open challenge is abandoned, a started one ended with its final standings.
+// A started challenge's final standings update ratings and global leaderboards as if
+// its creator had ended it; both are applied once per challenge, so a retry is harmless.
+// It is then persisted to MongoDB through the outbox and its Redis data and leaderboard
+// keys are removed.
+func (s *ChallengeService) handleChallengeExpired(ctx context.Context, challengeID string) {
+	claimed, err := s.GlobalState.Redis.ClaimChallengeExpiry(ctx, challengeID)
+	if err != nil {
+		log.Printf("[Expiry] %v", err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	ended := false
+	challenge, err := s.GlobalState.Redis.MutateChallenge(ctx, challengeID, func(challenge *model.ChallengeDocument) error {
+		ended = false
+		switch challenge.Status {
+		case model.ChallengeOpen:
+			challenge.Status = model.ChallengeAbandon
+		case model.ChallengeStarted:
+			challenge.Status = model.ChallengeEnded
+			challenge.Leaderboard = s.GlobalState.LeaderboardManager.GetFinalLeaderboard(challengeID, challenge)
+			ended = true
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, repo.ErrChallengeNotFound):
+		log.Printf("[Expiry] Challenge %s already removed, cleaning up leftovers", challengeID)
+		if err := s.GlobalState.Redis.DeleteChallenge(ctx, challengeID); err != nil {
+			log.Printf("[Expiry] Failed to delete expired challenge %s: %v", challengeID, err)
+		}
+	case err != nil:
+		log.Printf("[Expiry] Failed to close expired challenge %s: %v", challengeID, err)
+		return
+	default:
+		log.Printf("[Expiry] Challenge %s expired with status %s", challengeID, challenge.Status)
+		if ended {
+			if err := s.updateRatings(ctx, challengeID, challenge.Leaderboard); err != nil {
+				log.Printf("[Expiry] Failed to update ratings for expired challenge %s: %v", challengeID, err)
+			}
+			if err := s.updateGlobalLeaderboards(ctx, challengeID, challenge.Leaderboard); err != nil {
+				log.Printf("[Expiry] Failed to update global leaderboards for expired challenge %s: %v", challengeID, err)
+			}
+		}
+		// Persisting also deletes the Redis data. The status change above queued it in
+		// the outbox, which retries it if this attempt fails.
+		if err := s.persistChallengeToMongoDB(ctx, challengeID); err != nil {
+			log.Printf("[Expiry] Failed to persist expired challenge %s: %v", challengeID, err)
+		}
+	}
+
+	if err := s.GlobalState.LeaderboardManager.DeleteLeaderboard(ctx, challengeID); err != nil {
+		log.Printf("[Expiry] Failed to delete leaderboard of expired challenge %s: %v", challengeID, err)
+	}
+}
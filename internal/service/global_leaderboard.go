@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/rating"
+)
+
+// ErrInvalidSeason is returned for a season that is neither all-time nor YYYY-MM
+var ErrInvalidSeason = errors.New("invalid season")
+
+// updateGlobalLeaderboards folds a challenge's final standings into the all-time and
+// current seasonal leaderboards
+func (s *ChallengeService) updateGlobalLeaderboards(ctx context.Context, challengeID string, standings []*model.LeaderboardEntry) error {
+	if len(standings) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	season := model.SeasonFor(now)
+
+	// The winner must have scored and strictly beaten second place; ties on the user ID
+	// tiebreak, or rooms where nobody scored, have no winner
+	winner := ""
+	if len(standings) > 1 && standings[0].TotalScore > 0 && rating.CompareResults(standings[0], standings[1]) > 0 {
+		winner = standings[0].UserID
+	}
+
+	results := make([]model.ChallengeResult, 0, len(standings))
+	for _, entry := range standings {
+		results = append(results, model.ChallengeResult{
+			ChallengeID:    challengeID,
+			UserID:         entry.UserID,
+			Season:         season,
+			Rank:           entry.Rank,
+			Points:         entry.TotalScore,
+			ProblemsSolved: entry.ProblemsCompleted,
+			Won:            entry.UserID == winner,
+			CreatedAt:      now.Unix(),
+		})
+	}
+
+	// Results are aggregated once per challenge; a repeat call completes a partial earlier one
+	return s.GlobalState.Mongo.ApplyChallengeResults(ctx, challengeID, results)
+}
+
+// GetGlobalLeaderboard returns a page of the all-time or a monthly season leaderboard.
+// An empty season means all-time.
+func (s *ChallengeService) GetGlobalLeaderboard(ctx context.Context, season string, page, pageSize int) ([]model.GlobalLeaderboardEntry, int64, error) {
+	if season == "" {
+		season = model.SeasonAllTime
+	}
+
+	if season != model.SeasonAllTime {
+		if _, err := time.Parse(model.SeasonFormat, season); err != nil {
+			return nil, 0, fmt.Errorf("%w %q, expected %s or YYYY-MM", ErrInvalidSeason, season, model.SeasonAllTime)
+		}
+	}
+
+	return s.GlobalState.Mongo.GetGlobalLeaderboard(ctx, season, page, pageSize)
+}
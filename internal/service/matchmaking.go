@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/constants"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/matchmaking"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/broadcasts"
+	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
+	challengePb "github.com/lijuuu/GlobalProtoXcode/ChallengeService"
+)
+
+// HandleMatch creates a private challenge for a matchmaking group, with problems picked
+// to the group's preferences, and sends every matched user a MATCH_FOUND event with the
+// challenge ID, password and a join token
+func (s *ChallengeService) HandleMatch(match *matchmaking.Match) {
+	if match == nil || len(match.Tickets) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	challengeID := uuid.New().String()
+	creatorID := match.Tickets[0].UserID
+
+	userIDs := make([]string, 0, len(match.Tickets))
+	for _, ticket := range match.Tickets {
+		userIDs = append(userIDs, ticket.UserID)
+	}
+
+	req := &challengePb.ChallengeRecord{
+		ChallengeId:     challengeID,
+		CreatorId:       creatorID,
+		CreatedAt:       time.Now().Unix(),
+		Title:           fmt.Sprintf("Match %s", challengeID[:8]),
+		IsPrivate:       true,
+		TimeLimitMillis: constants.MatchTimeLimit.Milliseconds(),
+		Config: &challengePb.ChallengeConfig{
+			MaxUsers:           int32(match.Preferences.Size),
+			MaxEasyQuestions:   int32(match.Preferences.MaxEasyQuestions),
+			MaxMediumQuestions: int32(match.Preferences.MaxMediumQuestions),
+			MaxHardQuestions:   int32(match.Preferences.MaxHardQuestions),
+		},
+	}
+
+	problemIDs, err := s.pickProblems(ctx, match.Preferences.MaxEasyQuestions, match.Preferences.MaxMediumQuestions, match.Preferences.MaxHardQuestions, nil)
+	if err != nil {
+		log.Printf("[HandleMatch] Failed to pick problems for users %v: %v", userIDs, err)
+		s.requeueMatch(match, wsstypes.ErrCodeUnavailable, "No problems available for the match")
+		return
+	}
+	req.ProcessedProblemIds = problemIDs
+
+	// Matches are created directly: the one-open-challenge rule applies to challenges
+	// users create, and would block every match while another one is open
+	challengeDoc, err := s.createChallengeRecord(ctx, req)
+	if err != nil {
+		log.Printf("[HandleMatch] Failed to create challenge for users %v: %v", userIDs, err)
+		s.requeueMatch(match, wsstypes.ErrCodeInternal, "Failed to create match challenge")
+		return
+	}
+
+	log.Printf("[HandleMatch] Created challenge %s for users %v", challengeID, userIDs)
+
+	for _, ticket := range match.Tickets {
+		if ticket.Client == nil {
+			continue
+		}
+
+		token, err := s.GlobalState.JwtManager.GenerateToken(ticket.UserID, challengeID, constants.MatchTimeLimit+constants.BufferTime)
+		if err != nil {
+			log.Printf("[HandleMatch] Failed to generate join token for user %s: %v", ticket.UserID, err)
+			continue
+		}
+
+		payload := wsstypes.MatchFoundPayload{
+			ChallengeID:  challengeID,
+			CreatorID:    creatorID,
+			Password:     challengeDoc.Password,
+			Token:        token,
+			Participants: userIDs,
+			Preferences:  &match.Preferences,
+			Time:         time.Now(),
+		}
+
+		if err := broadcasts.SendStandardSuccess(ticket.Client, constants.MATCH_FOUND, payload); err != nil {
+			log.Printf("[HandleMatch] Failed to send match to user %s: %v", ticket.UserID, err)
+		}
+	}
+}
+
+// requeueMatch puts the users of a match that could not be created back in the queue,
+// and tells those who ran out of attempts that matching failed
+func (s *ChallengeService) requeueMatch(match *matchmaking.Match, code wsstypes.ErrorCode, message string) {
+	dropped := match.Tickets
+	if s.GlobalState.Matchmaker != nil {
+		dropped = s.GlobalState.Matchmaker.Requeue(match.Tickets)
+	}
+	notifyMatchFailed(dropped, code, message)
+}
+
+// notifyMatchFailed tells matched users that their match could not be created
+func notifyMatchFailed(tickets []*matchmaking.Ticket, code wsstypes.ErrorCode, message string) {
+	for _, ticket := range tickets {
+		if ticket.Client == nil {
+			continue
+		}
+		if err := broadcasts.SendStandardError(ticket.Client, constants.MATCH_FOUND, code, message); err != nil {
+			log.Printf("[HandleMatch] Failed to notify user %s: %v", ticket.UserID, err)
+		}
+	}
+}
+
+// pickProblems selects random problems for a challenge the service creates itself,
+// skipping the excluded ones
+func (s *ChallengeService) pickProblems(ctx context.Context, easy, medium, hard int, exclude []string) ([]string, error) {
+	if s.GlobalState.Problems == nil {
+		return nil, errors.New("problem selection is not configured")
+	}
+	return s.GlobalState.Problems.Pick(ctx, easy, medium, hard, exclude)
+}
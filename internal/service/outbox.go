@@ -0,0 +1,22 @@
+package service
+
+import (
+	"context"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+)
+
+// ListPersistJobs returns the challenges waiting to be persisted to MongoDB and those
+// whose persistence was dead-lettered after repeated failures
+func (s *ChallengeService) ListPersistJobs(ctx context.Context) (pending, dead []model.PersistJob, err error) {
+	return s.GlobalState.Redis.ListPersistJobs(ctx)
+}
+
+// RetryPersistJob requeues a challenge's persistence job with its attempts reset and
+// runs it now, returning the error if it fails again
+func (s *ChallengeService) RetryPersistJob(ctx context.Context, challengeID string) error {
+	if err := s.GlobalState.Redis.QueuePersistJob(ctx, challengeID); err != nil {
+		return err
+	}
+	return s.persistChallengeToMongoDB(ctx, challengeID)
+}
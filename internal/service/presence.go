@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/repo"
+)
+
+// GetChallengePresence returns the users connected to a challenge on any node
+func (s *ChallengeService) GetChallengePresence(ctx context.Context, challengeID string) ([]model.UserPresence, error) {
+	if challengeID == "" {
+		return nil, errors.New("challengeID cannot be empty")
+	}
+	if s.GlobalState == nil || s.GlobalState.Presence == nil {
+		return nil, errors.New("presence registry is not configured")
+	}
+
+	return s.GlobalState.Presence.Online(ctx, challengeID)
+}
+
+// IsParticipant reports whether the user has joined the challenge. A challenge that is
+// no longer in Redis has no participants.
+func (s *ChallengeService) IsParticipant(ctx context.Context, challengeID, userID string) (bool, error) {
+	challenge, err := s.GlobalState.Redis.GetChallengeByID(ctx, challengeID)
+	if errors.Is(err, repo.ErrChallengeNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get challenge %s: %w", challengeID, err)
+	}
+
+	_, ok := challenge.Participants[userID]
+	return ok, nil
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/rating"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/broadcasts"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// updateRatings computes Elo rating changes from the final standings, stores them in MongoDB
+// and sends each connected participant their RATING_UPDATE
+func (s *ChallengeService) updateRatings(ctx context.Context, challengeID string, standings []*model.LeaderboardEntry) error {
+	if len(standings) < 2 {
+		return nil
+	}
+
+	userIDs := make([]string, 0, len(standings))
+	for _, entry := range standings {
+		userIDs = append(userIDs, entry.UserID)
+	}
+
+	existing, err := s.GlobalState.Mongo.GetUserRatings(ctx, userIDs)
+	if err != nil {
+		return fmt.Errorf("failed to get user ratings: %w", err)
+	}
+
+	currentRatings := make(map[string]float64, len(existing))
+	for userID, userRating := range existing {
+		currentRatings[userID] = userRating.Rating
+	}
+
+	deltas := rating.ComputeEloDeltas(standings, currentRatings)
+
+	now := time.Now().Unix()
+	changes := make([]model.RatingChange, 0, len(standings))
+	for _, entry := range standings {
+		oldRating, exists := currentRatings[entry.UserID]
+		if !exists {
+			oldRating = rating.DefaultRating
+		}
+
+		changes = append(changes, model.RatingChange{
+			UserID:      entry.UserID,
+			ChallengeID: challengeID,
+			OldRating:   oldRating,
+			NewRating:   oldRating + deltas[entry.UserID],
+			Delta:       deltas[entry.UserID],
+			Rank:        entry.Rank,
+			CreatedAt:   now,
+		})
+	}
+
+	// Ratings are applied once per challenge; a repeat call completes a partial earlier
+	// one and only notifies users whose rating it changed
+	applied, err := s.GlobalState.Mongo.ApplyRatingChanges(ctx, challengeID, changes, rating.DefaultRating)
+	if err != nil {
+		return err
+	}
+
+	// Notify connected participants of their rating change
+	if s.GlobalState.Broadcaster != nil {
+		for _, change := range applied {
+			if err := broadcasts.BroadcastRatingUpdate(s.GlobalState.Broadcaster, change); err != nil {
+				log.Printf("[updateRatings] Failed to send rating update to user %s: %v", change.UserID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetUserRating returns a user's current rating, defaulting for users who haven't played yet
+func (s *ChallengeService) GetUserRating(ctx context.Context, userID string) (*model.UserRating, error) {
+	if userID == "" {
+		return nil, errors.New("userID cannot be empty")
+	}
+
+	userRating, err := s.GlobalState.Mongo.GetUserRating(ctx, userID)
+	if err == mongo.ErrNoDocuments {
+		return &model.UserRating{UserID: userID, Rating: rating.DefaultRating}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &userRating, nil
+}
+
+// GetRatingHistory returns a user's per-challenge rating changes, newest first
+func (s *ChallengeService) GetRatingHistory(ctx context.Context, userID string, page, pageSize int) ([]model.RatingChange, error) {
+	return s.GlobalState.Mongo.GetRatingHistory(ctx, userID, page, pageSize)
+}
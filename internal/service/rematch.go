@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/constants"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/rematch"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/broadcasts"
+	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
+	challengePb "github.com/lijuuu/GlobalProtoXcode/ChallengeService"
+)
+
+// HandleRematch creates a new challenge from a finished one once every prior participant
+// has accepted. The config, privacy and roster are copied, new problems are picked to the
+// same difficulty counts excluding every problem used earlier in the series, and both
+// challenges are linked as a series.
+func (s *ChallengeService) HandleRematch(r *rematch.Rematch) {
+	if r == nil || r.PreviousChallenge == nil {
+		return
+	}
+
+	ctx := context.Background()
+	previous := r.PreviousChallenge
+	challengeID := uuid.New().String()
+
+	seriesID := previous.SeriesID
+	if seriesID == "" {
+		seriesID = previous.ChallengeID
+	}
+
+	req := &challengePb.ChallengeRecord{
+		ChallengeId:     challengeID,
+		CreatorId:       previous.CreatorID,
+		CreatedAt:       time.Now().Unix(),
+		Title:           previous.Title,
+		IsPrivate:       previous.IsPrivate,
+		TimeLimitMillis: previous.TimeLimit,
+	}
+	if previous.Config != nil {
+		req.Config = &challengePb.ChallengeConfig{
+			MaxUsers:           int32(previous.Config.MaxUsers),
+			MaxEasyQuestions:   int32(previous.Config.MaxEasyQuestions),
+			MaxMediumQuestions: int32(previous.Config.MaxMediumQuestions),
+			MaxHardQuestions:   int32(previous.Config.MaxHardQuestions),
+		}
+	}
+
+	// Problems used anywhere earlier in the series are not picked again
+	excluded := append(append([]string{}, previous.ExcludedProblemIds...), previous.ProcessedProblemIds...)
+	if previous.Config == nil {
+		log.Printf("[HandleRematch] Challenge %s has no config to pick rematch problems from", previous.ChallengeID)
+		s.notifyRematchFailed(r, "Failed to create rematch challenge")
+		return
+	}
+	problemIDs, err := s.pickProblems(ctx, previous.Config.MaxEasyQuestions, previous.Config.MaxMediumQuestions, previous.Config.MaxHardQuestions, excluded)
+	if err != nil {
+		log.Printf("[HandleRematch] Failed to pick problems for rematch of challenge %s: %v", previous.ChallengeID, err)
+		s.notifyRematchFailed(r, "No unused problems available for the rematch")
+		return
+	}
+	req.ProcessedProblemIds = problemIDs
+
+	// Like matches, rematches skip the one-open-challenge check of user-created challenges
+	challengeDoc, err := s.createChallengeRecord(ctx, req)
+	if err != nil {
+		log.Printf("[HandleRematch] Failed to create rematch of challenge %s: %v", previous.ChallengeID, err)
+		s.notifyRematchFailed(r, "Failed to create rematch challenge")
+		return
+	}
+
+	// Link the series and carry over the problems already used
+	challengeDoc.SeriesID = seriesID
+	challengeDoc.PreviousChallengeID = previous.ChallengeID
+	challengeDoc.ExcludedProblemIds = excluded
+
+	// Pre-admit every prior participant
+	for _, userID := range r.RequiredUserIDs() {
+		if _, exists := challengeDoc.Participants[userID]; exists {
+			continue
+		}
+		challengeDoc.Participants[userID] = &model.ParticipantMetadata{
+			ProblemsDone: make(map[string]model.ChallengeProblemMetadata),
+			JoinTime:     time.Now().Unix(),
+		}
+		if err := s.GlobalState.LeaderboardManager.UpdateParticipantScore(challengeID, userID, 0); err != nil {
+			log.Printf("[HandleRematch] Warning: Failed to add user %s to leaderboard for challenge %s: %v", userID, challengeID, err)
+		}
+	}
+
+	if err := s.GlobalState.Redis.UpdateChallenge(ctx, challengeDoc); err != nil {
+		log.Printf("[HandleRematch] Failed to store rematch challenge %s: %v", challengeID, err)
+		s.notifyRematchFailed(r, "Failed to create rematch challenge")
+		return
+	}
+
+	if err := s.GlobalState.Mongo.SetChallengeSeries(ctx, previous.ChallengeID, seriesID); err != nil {
+		log.Printf("[HandleRematch] Warning: Failed to link challenge %s to series %s: %v", previous.ChallengeID, seriesID, err)
+	}
+
+	log.Printf("[HandleRematch] Created rematch %s of challenge %s (series %s)", challengeID, previous.ChallengeID, seriesID)
+
+	expiration := time.Duration(previous.TimeLimit)*time.Millisecond + constants.BufferTime
+	for userID, c := range r.Accepted {
+		if c == nil {
+			continue
+		}
+
+		token, err := s.GlobalState.JwtManager.GenerateToken(userID, challengeID, expiration)
+		if err != nil {
+			log.Printf("[HandleRematch] Failed to generate join token for user %s: %v", userID, err)
+			continue
+		}
+
+		payload := wsstypes.MatchFoundPayload{
+			ChallengeID:         challengeID,
+			PreviousChallengeID: previous.ChallengeID,
+			SeriesID:            seriesID,
+			CreatorID:           challengeDoc.CreatorID,
+			Password:            challengeDoc.Password,
+			Token:               token,
+			Participants:        r.RequiredUserIDs(),
+			Time:                time.Now(),
+		}
+
+		if err := broadcasts.SendStandardSuccess(c, constants.MATCH_FOUND, payload); err != nil {
+			log.Printf("[HandleRematch] Failed to send rematch to user %s: %v", userID, err)
+		}
+	}
+}
+
+// notifyRematchFailed tells every accepting user that the rematch could not be created
+func (s *ChallengeService) notifyRematchFailed(r *rematch.Rematch, message string) {
+	for userID, c := range r.Accepted {
+		if c == nil {
+			continue
+		}
+		if err := broadcasts.SendStandardError(c, constants.REMATCH_ACCEPT, wsstypes.ErrCodeInternal, message); err != nil {
+			log.Printf("[HandleRematch] Failed to notify user %s: %v", userID, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package wss
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/jwt"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/codec"
+)
+
+const (
+	// BearerSubprotocol marks the next Sec-WebSocket-Protocol entry as the token,
+	// e.g. new WebSocket(url, ["bearer", token]) in browsers
+	BearerSubprotocol = "bearer"
+
+	// TokenParam is the query parameter and cookie name carrying the token
+	TokenParam = "token"
+)
+
+// upgradeToken extracts the challenge token from an upgrade request. It checks, in order,
+// the Sec-WebSocket-Protocol header, the Authorization header, the token query parameter
+// and the token cookie. viaSubprotocol reports whether the bearer subprotocol must be
+// echoed back for the handshake to succeed.
+func upgradeToken(r *http.Request) (token string, viaSubprotocol bool) {
+	protocols := websocket.Subprotocols(r)
+	for i, p := range protocols {
+		if p == BearerSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1], true
+		}
+	}
+
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if after, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return after, false
+		}
+		return auth, false
+	}
+
+	if token := r.URL.Query().Get(TokenParam); token != "" {
+		return token, false
+	}
+
+	if cookie, err := r.Cookie(TokenParam); err == nil && cookie.Value != "" {
+		return cookie.Value, false
+	}
+
+	return "", false
+}
+
+// authenticateUpgrade validates the token on an upgrade request, if one was sent.
+// It returns nil claims for anonymous upgrades, which must then authenticate per
+// message. viaSubprotocol reports whether the token came as the bearer subprotocol.
+func authenticateUpgrade(r *http.Request, jwtManager *jwt.JWTManager) (claims *jwt.CustomClaims, viaSubprotocol bool, err error) {
+	token, viaSubprotocol := upgradeToken(r)
+	if token == "" {
+		return nil, false, nil
+	}
+	if jwtManager == nil {
+		return nil, false, errors.New("token authentication is not configured")
+	}
+
+	claims, err = jwtManager.ValidateToken(token)
+	if err != nil {
+		return nil, false, err
+	}
+	return claims, viaSubprotocol, nil
+}
+
+// negotiateSubprotocol picks the subprotocol to echo in the handshake and the codec for
+// the connection: the first codec the client offers, else the bearer marker if the
+// token came that way (browsers fail the handshake if none of their offers is echoed),
+// else none with JSON.
+func negotiateSubprotocol(r *http.Request, bearer bool) (string, codec.Codec) {
+	for _, p := range websocket.Subprotocols(r) {
+		if cd, ok := codec.ByName(p); ok {
+			return p, cd
+		}
+	}
+	if bearer {
+		return BearerSubprotocol, codec.JSON
+	}
+	return "", codec.JSON
+}
@@ -1,152 +1,180 @@
 package broadcasts
 
 import (
+	"context"
+	"encoding/json"
+	"log"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/broadcaster"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/constants"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/client"
 	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
 )
 
-// SendJSON sends a JSON message over a single WebSocket connection.
-func SendJSON(conn *websocket.Conn, data interface{}) error {
-	return conn.WriteJSON(data)
+// coalescibleEvents are state snapshots where a newer message supersedes a queued older one,
+// so slow clients may skip intermediate updates
+var coalescibleEvents = map[string]bool{
+	constants.LEADERBOARD_UPDATE: true,
+}
+
+// SendJSON queues a JSON message on a single WebSocket client.
+func SendJSON(c *client.Client, data interface{}) error {
+	return c.SendJSON(data)
 }
 
 // SendStandardMessage sends a standardized message to a single WebSocket connection.
 // This is the core function for sending any standard broadcast message.
-func SendStandardMessage(conn *websocket.Conn, msgType string, payload any, success bool, errorMsg *string) error {
+func SendStandardMessage(c *client.Client, msgType string, payload any, success bool, wsErr *wsstypes.WsError) error {
 	message := wsstypes.StandardBroadcastMessage{
 		Type:    msgType,
 		Payload: payload,
 		Success: success,
-		Error:   errorMsg,
+		Error:   wsErr,
 	}
-	return SendJSON(conn, message)
+	return SendJSON(c, message)
 }
 
 // BroadcastStandardMessage broadcasts a standardized message to all provided WebSocket clients.
 // This is the core function for broadcasting any standard broadcast message.
-func BroadcastStandardMessage(wsClients map[string]*websocket.Conn, msgType string, payload any, success bool, errorMsg *string) {
+func BroadcastStandardMessage(wsClients map[string]*client.Client, msgType string, payload any, success bool, wsErr *wsstypes.WsError) {
 	message := wsstypes.StandardBroadcastMessage{
 		Type:    msgType,
 		Payload: payload,
 		Success: success,
-		Error:   errorMsg,
+		Error:   wsErr,
 	}
 
-	// Sending to each connection concurrently to avoid blocking
-	for _, conn := range wsClients {
-		if conn == nil {
-			continue // Skip nil connections
-		}
-		// Goroutine for non-blocking send, handle errors appropriately
-		go func(c *websocket.Conn) {
-			if err := SendJSON(c, message); err != nil {
-				// Log the error, or handle it as needed.
-				// For example, if a write fails, it might indicate a closed connection
-				// and you might want to remove it from the wsClients map.
-				// log.Printf("Error sending message to client: %v", err)
-			}
-		}(conn)
+	// Encode once and queue on each client; the per-client write pump does the actual write
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("[Broadcast] failed to marshal %s: %v", msgType, err)
+		return
 	}
+
+	queueOnClients(wsClients, msgType, data)
+}
+
+// queueOnClients queues a JSON message on every client, coalescing snapshot events
+func queueOnClients(wsClients map[string]*client.Client, msgType string, data []byte) {
+	broadcaster.Deliver(wsClients, msgType, data, coalescibleEvents[msgType])
 }
 
 // SendStandardError sends a standardized error message to a single WebSocket connection.
-func SendStandardError(conn *websocket.Conn, msgType string, errorMsg string) error {
-	errorPtr := &errorMsg
-	return SendStandardMessage(conn, msgType, nil, false, errorPtr)
+func SendStandardError(c *client.Client, msgType string, code wsstypes.ErrorCode, errorMsg string) error {
+	return SendStandardMessage(c, msgType, nil, false, &wsstypes.WsError{Code: code, Message: errorMsg})
 }
 
 // BroadcastStandardError broadcasts a standardized error message to all WebSocket clients.
-func BroadcastStandardError(wsClients map[string]*websocket.Conn, msgType string, errorMsg string) {
-	errorPtr := &errorMsg
-	BroadcastStandardMessage(wsClients, msgType, nil, false, errorPtr)
+func BroadcastStandardError(wsClients map[string]*client.Client, msgType string, code wsstypes.ErrorCode, errorMsg string) {
+	BroadcastStandardMessage(wsClients, msgType, nil, false, &wsstypes.WsError{Code: code, Message: errorMsg})
 }
 
 // SendStandardSuccess sends a standardized success message to a single WebSocket connection.
-func SendStandardSuccess(conn *websocket.Conn, msgType string, payload any) error {
-	return SendStandardMessage(conn, msgType, payload, true, nil)
+func SendStandardSuccess(c *client.Client, msgType string, payload any) error {
+	return SendStandardMessage(c, msgType, payload, true, nil)
 }
 
 // BroadcastStandardSuccess broadcasts a standardized success message to all WebSocket clients.
-func BroadcastStandardSuccess(wsClients map[string]*websocket.Conn, msgType string, payload any) {
+func BroadcastStandardSuccess(wsClients map[string]*client.Client, msgType string, payload any) {
 	BroadcastStandardMessage(wsClients, msgType, payload, true, nil)
 }
 
-// SendErrorWithType sends a standardized error message with additional payload details to a single WebSocket connection.
-// The `extra` map is passed as the `Payload`.
-func SendErrorWithType(conn *websocket.Conn, eventType string, msg string, extra map[string]any) error {
-	errorMsg := msg
-	return SendStandardMessage(conn, eventType, extra, false, &errorMsg)
-}
-
-// BroadcastEntityJoinedWithClients broadcasts a user/owner joined event to WebSocket clients.
-func BroadcastEntityJoinedWithClients(wsClients map[string]*websocket.Conn, userID, challengeID string, isOwner bool) {
+// BroadcastEntityJoined broadcasts a user/owner joined event to the challenge's connections.
+func BroadcastEntityJoined(b broadcaster.Broadcaster, events EventLog, userID, challengeID string, isOwner bool) {
 	eventType := constants.USER_JOINED
 	if isOwner {
 		eventType = constants.OWNER_JOINED
 	}
 
-	payload := map[string]any{
-		"userId":      userID,
-		"challengeId": challengeID,
-		"time":        time.Now(),
+	payload := wsstypes.ParticipantEventPayload{
+		ChallengeID: challengeID,
+		UserID:      userID,
+		Time:        time.Now(),
 	}
 
-	BroadcastStandardMessage(wsClients, eventType, payload, true, nil)
+	BroadcastChallengeEvent(b, events, challengeID, eventType, payload)
 }
 
-// BroadcastEntityLeftWithClients broadcasts a user/owner left event to WebSocket clients.
-func BroadcastEntityLeftWithClients(wsClients map[string]*websocket.Conn, userID, challengeID string, isOwner bool) {
+// BroadcastEntityLeft broadcasts a user/owner left event to the challenge's connections.
+func BroadcastEntityLeft(b broadcaster.Broadcaster, events EventLog, userID, challengeID string, isOwner bool) {
 	eventType := constants.USER_LEFT
 	if isOwner {
 		eventType = constants.OWNER_LEFT
 	}
 
-	payload := map[string]any{
-		"userId":      userID,
-		"challengeId": challengeID,
-		"time":        time.Now(),
+	payload := wsstypes.ParticipantEventPayload{
+		ChallengeID: challengeID,
+		UserID:      userID,
+		Time:        time.Now(),
 	}
 
-	BroadcastStandardMessage(wsClients, eventType, payload, true, nil)
+	BroadcastChallengeEvent(b, events, challengeID, eventType, payload)
 }
 
-// BroadcastChallengeAbandonWithClients broadcasts a challenge abandon event to WebSocket clients.
-func BroadcastChallengeAbandonWithClients(wsClients map[string]*websocket.Conn, challengeID, creatorID string) {
-	payload := map[string]any{
-		"challengeId": challengeID,
-		"userId":      creatorID,
-		"time":        time.Now(),
+// BroadcastChallengeAbandon broadcasts a challenge abandon event to the challenge's connections.
+func BroadcastChallengeAbandon(b broadcaster.Broadcaster, events EventLog, challengeID, creatorID string) {
+	payload := wsstypes.ParticipantEventPayload{
+		ChallengeID: challengeID,
+		UserID:      creatorID,
+		Time:        time.Now(),
 	}
 
-	BroadcastStandardMessage(wsClients, constants.CREATOR_ABANDON, payload, true, nil)
+	BroadcastChallengeEvent(b, events, challengeID, constants.CREATOR_ABANDON, payload)
 }
 
-// BroadcastNewSubmission broadcasts NEW_SUBMISSION event to WebSocket clients.
-func BroadcastNewSubmission(wsClients map[string]*websocket.Conn, challengeID, userID, problemID string, score, newRank int) {
-	payload := map[string]any{
-		"challengeId": challengeID,
-		"userId":      userID,
-		"problemId":   problemID,
-		"score":       score,
-		"newRank":     newRank,
-		"time":        time.Now(),
+// BroadcastNewSubmission broadcasts NEW_SUBMISSION event to the challenge's connections.
+func BroadcastNewSubmission(b broadcaster.Broadcaster, events EventLog, challengeID, userID, problemID string, score, newRank int) {
+	payload := wsstypes.NewSubmissionPayload{
+		ChallengeID: challengeID,
+		UserID:      userID,
+		ProblemID:   problemID,
+		Score:       score,
+		NewRank:     newRank,
+		Time:        time.Now(),
 	}
 
-	BroadcastStandardMessage(wsClients, constants.NEW_SUBMISSION, payload, true, nil)
+	BroadcastChallengeEvent(b, events, challengeID, constants.NEW_SUBMISSION, payload)
 }
 
-// BroadcastLeaderboardUpdate broadcasts LEADERBOARD_UPDATE event to WebSocket clients.
-func BroadcastLeaderboardUpdate(wsClients map[string]*websocket.Conn, challengeID string, leaderboard []*model.LeaderboardEntry, updatedUser string) {
-	payload := map[string]any{
-		"challengeId": challengeID,
-		"leaderboard": leaderboard,
-		"updatedUser": updatedUser,
-		"time":        time.Now(),
+// BroadcastLeaderboardUpdate broadcasts LEADERBOARD_UPDATE event to the challenge's connections.
+func BroadcastLeaderboardUpdate(b broadcaster.Broadcaster, events EventLog, challengeID string, leaderboard []*model.LeaderboardEntry, updatedUser string) {
+	payload := wsstypes.LeaderboardUpdatePayload{
+		ChallengeID: challengeID,
+		Leaderboard: leaderboard,
+		UpdatedUser: updatedUser,
+		Time:        time.Now(),
+	}
+
+	BroadcastChallengeEvent(b, events, challengeID, constants.LEADERBOARD_UPDATE, payload)
+}
+
+// BroadcastRatingUpdate sends a RATING_UPDATE event with a user's rating change to all of their connections.
+func BroadcastRatingUpdate(b broadcaster.Broadcaster, change model.RatingChange) error {
+	payload := wsstypes.RatingUpdatePayload{
+		ChallengeID: change.ChallengeID,
+		UserID:      change.UserID,
+		OldRating:   change.OldRating,
+		NewRating:   change.NewRating,
+		Delta:       change.Delta,
+		Rank:        change.Rank,
+		Time:        time.Now(),
+	}
+
+	data, err := json.Marshal(wsstypes.StandardBroadcastMessage{
+		Type:    constants.RATING_UPDATE,
+		Payload: payload,
+		Success: true,
+	})
+	if err != nil {
+		return err
 	}
 
-	BroadcastStandardMessage(wsClients, constants.LEADERBOARD_UPDATE, payload, true, nil)
+	return b.Publish(context.Background(), broadcaster.Message{
+		ChallengeID: change.ChallengeID,
+		Type:        constants.RATING_UPDATE,
+		UserID:      change.UserID,
+		Data:        data,
+	})
 }
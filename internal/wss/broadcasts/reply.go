@@ -0,0 +1,47 @@
+package broadcasts
+
+import (
+	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
+)
+
+// Reply sends a standardized success message answering the request in ctx,
+// echoing its request ID.
+func Reply(ctx *wsstypes.WsContext, msgType string, payload any) error {
+	ctx.MarkReplied()
+	return SendJSON(ctx.Client, wsstypes.StandardBroadcastMessage{
+		Type:      msgType,
+		RequestID: ctx.RequestID,
+		Payload:   payload,
+		Success:   true,
+	})
+}
+
+// ReplyError sends a standardized error message answering the request in ctx,
+// echoing its request ID.
+func ReplyError(ctx *wsstypes.WsContext, msgType string, code wsstypes.ErrorCode, msg string) error {
+	ctx.MarkReplied()
+	return SendJSON(ctx.Client, wsstypes.StandardBroadcastMessage{
+		Type:      msgType,
+		RequestID: ctx.RequestID,
+		Success:   false,
+		Error:     &wsstypes.WsError{Code: code, Message: msg},
+	})
+}
+
+// SendAck acknowledges a request that completed without a direct reply.
+func SendAck(ctx *wsstypes.WsContext, event string) error {
+	return Reply(ctx, wsstypes.ACK, wsstypes.RequestEventPayload{Event: event})
+}
+
+// SendRequestError reports a request that failed without a direct reply,
+// such as an unknown event type or a handler error.
+func SendRequestError(ctx *wsstypes.WsContext, event string, code wsstypes.ErrorCode, msg string) error {
+	ctx.MarkReplied()
+	return SendJSON(ctx.Client, wsstypes.StandardBroadcastMessage{
+		Type:      wsstypes.ERROR,
+		RequestID: ctx.RequestID,
+		Payload:   wsstypes.RequestEventPayload{Event: event},
+		Success:   false,
+		Error:     &wsstypes.WsError{Code: code, Message: msg},
+	})
+}
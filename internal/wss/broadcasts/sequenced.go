@@ -0,0 +1,78 @@
+package broadcasts
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/broadcaster"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/repo"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/client"
+	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
+)
+
+// EventLog assigns per-challenge sequence numbers and keeps recent events for RESUME.
+// deliver runs while the challenge's sequence is held, so events go out in order.
+type EventLog interface {
+	AppendChallengeEvent(ctx context.Context, challengeID, eventType string, payload []byte, deliver func(seq int64)) (int64, error)
+}
+
+// BroadcastChallengeEvent publishes a per-challenge event stamped with the challenge's
+// next sequence number, after appending it to the event log for replay. Coalescible
+// snapshots are not sequenced, since dropping one would leave a hole clients mistake
+// for a missed event; a later snapshot replaces them anyway. If the log is unavailable
+// the event is still delivered, without a sequence number.
+func BroadcastChallengeEvent(b broadcaster.Broadcaster, events EventLog, challengeID, msgType string, payload any) {
+	payloadData, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("[Broadcast] failed to marshal %s payload: %v", msgType, err)
+		return
+	}
+
+	if events == nil || coalescibleEvents[msgType] {
+		publishChallengeEvent(b, challengeID, msgType, 0, payloadData)
+		return
+	}
+
+	_, err = events.AppendChallengeEvent(context.Background(), challengeID, msgType, payloadData, func(seq int64) {
+		publishChallengeEvent(b, challengeID, msgType, seq, payloadData)
+	})
+	if err != nil {
+		log.Printf("[Broadcast] failed to sequence %s for challenge %s: %v", msgType, challengeID, err)
+		publishChallengeEvent(b, challengeID, msgType, 0, payloadData)
+	}
+}
+
+// publishChallengeEvent wraps a marshaled payload in the broadcast envelope and publishes it
+func publishChallengeEvent(b broadcaster.Broadcaster, challengeID, msgType string, seq int64, payloadData []byte) {
+	data, err := json.Marshal(wsstypes.StandardBroadcastMessage{
+		Type:    msgType,
+		Seq:     seq,
+		Payload: json.RawMessage(payloadData),
+		Success: true,
+	})
+	if err != nil {
+		log.Printf("[Broadcast] failed to marshal %s: %v", msgType, err)
+		return
+	}
+
+	err = b.Publish(context.Background(), broadcaster.Message{
+		ChallengeID: challengeID,
+		Type:        msgType,
+		Coalesce:    coalescibleEvents[msgType],
+		Data:        data,
+	})
+	if err != nil {
+		log.Printf("[Broadcast] failed to publish %s for challenge %s: %v", msgType, challengeID, err)
+	}
+}
+
+// ReplayChallengeEvent re-sends a logged event to a single client exactly as it was broadcast
+func ReplayChallengeEvent(c *client.Client, event repo.ChallengeEvent) error {
+	return SendJSON(c, wsstypes.StandardBroadcastMessage{
+		Type:    event.Type,
+		Seq:     event.Seq,
+		Payload: event.Payload,
+		Success: true,
+	})
+}
@@ -0,0 +1,417 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/codec"
+)
+
+const (
+	// CloseSlowConsumer is sent to clients evicted for falling too far behind
+	CloseSlowConsumer = 4008
+	// CloseSessionReplaced is sent to a user's older connections when a newer one
+	// joins the same challenge and only one session per user is allowed
+	CloseSessionReplaced = 4009
+
+	// maxWriteBatch caps how many queued messages the write pump takes at once
+	maxWriteBatch = 32
+)
+
+// closeReasons are the reason texts sent with application close codes
+var closeReasons = map[int]string{
+	CloseSlowConsumer:    "slow consumer",
+	CloseSessionReplaced: "session replaced",
+}
+
+var (
+	ErrClientClosed = errors.New("client is closed")
+	ErrQueueFull    = errors.New("client send queue is full")
+)
+
+// Process-wide counters, published with expvar and served at /admin/vars
+var (
+	droppedMessages   = expvar.NewInt("ws_dropped_messages")
+	coalescedMessages = expvar.NewInt("ws_coalesced_messages")
+	evictedClients    = expvar.NewInt("ws_evicted_clients")
+)
+
+// Policy controls how a client's outbound queue handles slow consumers
+type Policy struct {
+	QueueSize    int           // max queued messages
+	WriteWait    time.Duration // deadline for a single write
+	MaxLag       time.Duration // how long the queue may stay full of undroppable messages before snapshots evict the client
+	PingInterval time.Duration // how often to ping the peer; must be shorter than the reader's pong wait
+	Compression  Compression   // permessage-deflate for outbound messages, if negotiated
+}
+
+// DefaultPolicy is used when no policy is configured
+var DefaultPolicy = Policy{
+	QueueSize:    256,
+	WriteWait:    10 * time.Second,
+	MaxLag:       30 * time.Second,
+	PingInterval: 54 * time.Second,
+}
+
+// Stats holds per-client delivery counters
+type Stats struct {
+	Sent      uint64 `json:"sent"`
+	Dropped   uint64 `json:"dropped"`
+	Coalesced uint64 `json:"coalesced"`
+}
+
+type message struct {
+	data        []byte
+	coalesceKey string
+}
+
+// Client wraps a WebSocket connection with a bounded outbound queue drained by a
+// single write pump, since gorilla/websocket allows only one concurrent writer.
+// All writes to a connection must go through its Client.
+//
+// When the queue is full, the oldest coalescible message is dropped to make room.
+// If nothing can be dropped, a non-coalescible message disconnects the client with
+// CloseSlowConsumer at once, while a coalescible one is discarded and the client is
+// disconnected only once its queue stays full for longer than Policy.MaxLag.
+type Client struct {
+	id        string
+	conn      *websocket.Conn
+	wire      *countingConn // set when the upgrade went through CountWrites
+	codec     codec.Codec
+	policy    Policy
+	queue     []message
+	notify    chan struct{}
+	closed    bool
+	closeCode int
+	fullSince time.Time
+	mu        sync.Mutex
+
+	sent      atomic.Uint64
+	dropped   atomic.Uint64
+	coalesced atomic.Uint64
+}
+
+// NewClient wraps conn and starts its write pump. Messages are encoded with cd,
+// the codec negotiated for the connection; nil means JSON.
+func NewClient(conn *websocket.Conn, cd codec.Codec, policy Policy) *Client {
+	if cd == nil {
+		cd = codec.JSON
+	}
+	if policy.QueueSize <= 0 {
+		policy.QueueSize = DefaultPolicy.QueueSize
+	}
+	if policy.WriteWait <= 0 {
+		policy.WriteWait = DefaultPolicy.WriteWait
+	}
+	if policy.MaxLag <= 0 {
+		policy.MaxLag = DefaultPolicy.MaxLag
+	}
+	if policy.PingInterval <= 0 {
+		policy.PingInterval = DefaultPolicy.PingInterval
+	}
+
+	if policy.Compression.Enabled {
+		if err := conn.SetCompressionLevel(policy.Compression.Level); err != nil {
+			log.Printf("[WS] invalid compression level %d, using %d: %v", policy.Compression.Level, DefaultCompression.Level, err)
+			conn.SetCompressionLevel(DefaultCompression.Level)
+		}
+	}
+
+	c := &Client{
+		id:        uuid.NewString(),
+		conn:      conn,
+		codec:     cd,
+		policy:    policy,
+		notify:    make(chan struct{}, 1),
+		closeCode: websocket.CloseNormalClosure,
+	}
+
+	c.wire, _ = conn.UnderlyingConn().(*countingConn)
+
+	go c.writePump()
+	return c
+}
+
+// SendJSON encodes v as JSON and queues it for sending
+func (c *Client) SendJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(data)
+}
+
+// Send encodes a JSON message with the client's codec and queues it without blocking
+func (c *Client) Send(data []byte) error {
+	wire, err := c.codec.FromJSON(data)
+	if err != nil {
+		return err
+	}
+	return c.SendEncoded(wire)
+}
+
+// SendCoalescible queues a message that supersedes any queued message with the same key.
+// Use for state snapshots such as leaderboard updates where only the latest matters.
+func (c *Client) SendCoalescible(key string, data []byte) error {
+	wire, err := c.codec.FromJSON(data)
+	if err != nil {
+		return err
+	}
+	return c.SendCoalescibleEncoded(key, wire)
+}
+
+// SendEncoded queues a message already encoded with the client's codec
+func (c *Client) SendEncoded(wire []byte) error {
+	return c.enqueue(message{data: wire})
+}
+
+// SendCoalescibleEncoded is SendCoalescible for a message already encoded with the client's codec
+func (c *Client) SendCoalescibleEncoded(key string, wire []byte) error {
+	return c.enqueue(message{data: wire, coalesceKey: key})
+}
+
+// Codec returns the codec negotiated for the connection
+func (c *Client) Codec() codec.Codec {
+	return c.codec
+}
+
+func (c *Client) enqueue(msg message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClientClosed
+	}
+
+	// A newer snapshot replaces the queued one, keeping causal order with other events
+	if msg.coalesceKey != "" {
+		if i := c.indexOf(func(m message) bool { return m.coalesceKey == msg.coalesceKey }); i >= 0 {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			c.coalesced.Add(1)
+			coalescedMessages.Add(1)
+		}
+	}
+
+	if len(c.queue) >= c.policy.QueueSize {
+		// Make room by dropping the oldest coalescible message
+		if i := c.indexOf(func(m message) bool { return m.coalesceKey != "" }); i >= 0 {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			c.recordDrop()
+		} else if msg.coalesceKey == "" {
+			// Losing an event the client cannot rebuild from a later snapshot would
+			// silently corrupt its state, so disconnect it and let it resume instead
+			c.recordDrop()
+			log.Printf("[WS] evicting slow consumer %s: queue full with %d undroppable messages", c.conn.RemoteAddr(), len(c.queue))
+			evictedClients.Add(1)
+			c.queue = nil
+			c.closeLocked(CloseSlowConsumer)
+			return ErrQueueFull
+		} else {
+			// A snapshot is superseded by the next one, so it can be discarded for a while
+			c.recordDrop()
+
+			if c.fullSince.IsZero() {
+				c.fullSince = time.Now()
+			} else if time.Since(c.fullSince) > c.policy.MaxLag {
+				log.Printf("[WS] evicting slow consumer %s: queue full for %v", c.conn.RemoteAddr(), time.Since(c.fullSince))
+				evictedClients.Add(1)
+				c.queue = nil
+				c.closeLocked(CloseSlowConsumer)
+			}
+			return ErrQueueFull
+		}
+	}
+
+	c.queue = append(c.queue, msg)
+	c.signal()
+	return nil
+}
+
+// indexOf returns the index of the first queued message matching fn, or -1; c.mu must be held
+func (c *Client) indexOf(fn func(message) bool) int {
+	for i, m := range c.queue {
+		if fn(m) {
+			return i
+		}
+	}
+	return -1
+}
+
+// recordDrop counts a dropped message; c.mu must be held
+func (c *Client) recordDrop() {
+	c.dropped.Add(1)
+	droppedMessages.Add(1)
+}
+
+// signal wakes the write pump without blocking
+func (c *Client) signal() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops accepting messages; queued messages are flushed before the
+// connection is closed. Safe to call multiple times.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeLocked(websocket.CloseNormalClosure)
+	return nil
+}
+
+// CloseWithCode is Close with an application close code such as CloseSessionReplaced
+func (c *Client) CloseWithCode(code int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closeLocked(code)
+}
+
+// Closed reports whether the client stopped accepting messages
+func (c *Client) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// closeLocked marks the client closed with the given close code; c.mu must be held
+func (c *Client) closeLocked(code int) {
+	if c.closed {
+		return
+	}
+
+	c.closed = true
+	c.closeCode = code
+	c.signal()
+}
+
+// markFailed stops accepting messages after a write failure. The pump then closes the
+// connection, which unblocks the reader and runs the normal disconnect path.
+func (c *Client) markFailed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	c.queue = nil
+}
+
+// ID returns the connection ID, unique per connection even for the same user
+func (c *Client) ID() string {
+	return c.id
+}
+
+// Conn returns the underlying WebSocket connection for reading
+func (c *Client) Conn() *websocket.Conn {
+	return c.conn
+}
+
+// RemoteAddr returns the remote network address of the connection
+func (c *Client) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// QueueSize returns the maximum number of messages the client can have queued
+func (c *Client) QueueSize() int {
+	return c.policy.QueueSize
+}
+
+// Stats returns the client's delivery counters
+func (c *Client) Stats() Stats {
+	return Stats{
+		Sent:      c.sent.Load(),
+		Dropped:   c.dropped.Load(),
+		Coalesced: c.coalesced.Load(),
+	}
+}
+
+// writePump is the only goroutine that writes to the connection; it also sends
+// periodic pings so the reader's deadline is refreshed by the peer's pongs
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.policy.PingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.policy.WriteWait)); err != nil {
+				log.Printf("[WS] ping error to %s: %v", c.conn.RemoteAddr(), err)
+				c.markFailed()
+				return
+			}
+			continue
+		case <-c.notify:
+		}
+
+		// Take a bounded batch so the messages in flight never add more than
+		// maxWriteBatch to the queue's capacity
+		c.mu.Lock()
+		n := min(len(c.queue), maxWriteBatch)
+		batch := make([]message, n)
+		copy(batch, c.queue)
+		c.queue = c.queue[n:]
+		c.fullSince = time.Time{}
+		more := len(c.queue) > 0
+		closed := c.closed && !more
+		closeCode := c.closeCode
+		c.mu.Unlock()
+
+		for _, msg := range batch {
+			if err := c.write(msg.data); err != nil {
+				log.Printf("[WS] write error to %s: %v", c.conn.RemoteAddr(), err)
+				c.markFailed()
+				return
+			}
+			c.sent.Add(1)
+		}
+
+		if more {
+			c.signal()
+		}
+
+		if closed {
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, closeReasons[closeCode]), time.Now().Add(c.policy.WriteWait))
+			return
+		}
+	}
+}
+
+// write sends one message, compressing it only if it reaches the compression threshold;
+// small messages cost more CPU to deflate than they save. Only called by the write pump.
+func (c *Client) write(data []byte) error {
+	compress := c.policy.Compression.Enabled && len(data) >= c.policy.Compression.Threshold
+	c.conn.EnableWriteCompression(compress)
+	c.conn.SetWriteDeadline(time.Now().Add(c.policy.WriteWait))
+
+	var before int64
+	if c.wire != nil {
+		before = c.wire.written.Load()
+	}
+
+	if err := c.conn.WriteMessage(c.codec.MessageType(), data); err != nil {
+		return err
+	}
+
+	switch {
+	case compress && c.wire != nil:
+		recordCompression(len(data), c.wire.written.Load()-before)
+	case compress:
+		compressedMessages.Add(1)
+	case c.policy.Compression.Enabled:
+		uncompressedMessages.Add(1)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Compression counters, published with expvar and served at /admin/vars. Bytes in are message sizes
+// before compression, bytes out are the frames written to the wire.
+var (
+	compressedMessages    = expvar.NewInt("ws_compressed_messages")
+	uncompressedMessages  = expvar.NewInt("ws_uncompressed_messages")
+	compressionBytesIn    = expvar.NewInt("ws_compression_bytes_in")
+	compressionBytesOut   = expvar.NewInt("ws_compression_bytes_out")
+	compressionBytesSaved = expvar.NewInt("ws_compression_bytes_saved")
+)
+
+// Compression controls permessage-deflate for outbound messages. It only applies when
+// the peer negotiated the extension during the upgrade.
+type Compression struct {
+	Enabled   bool
+	Level     int // flate level, from 1 (fastest) to 9 (smallest)
+	Threshold int // messages smaller than this many bytes are sent uncompressed
+}
+
+// DefaultCompression is used when compression is enabled without a valid level
+var DefaultCompression = Compression{
+	Enabled:   true,
+	Level:     flate.BestSpeed,
+	Threshold: 1024,
+}
+
+// OffersDeflate reports whether an upgrade request offers permessage-deflate
+func OffersDeflate(r *http.Request) bool {
+	for _, value := range r.Header.Values("Sec-Websocket-Extensions") {
+		for _, ext := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.TrimSpace(name) == "permessage-deflate" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CountWrites wraps w so the connection it hijacks for an upgrade counts the bytes
+// written to it, letting the client measure what compression saved on the wire
+func CountWrites(w http.ResponseWriter) http.ResponseWriter {
+	return countingResponseWriter{w}
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingConn{Conn: conn}, brw, nil
+}
+
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// recordCompression counts a compressed message of size bytes that took wire bytes to send
+func recordCompression(size int, wire int64) {
+	compressedMessages.Add(1)
+	compressionBytesIn.Add(int64(size))
+	compressionBytesOut.Add(wire)
+	compressionBytesSaved.Add(int64(size) - wire)
+}
@@ -0,0 +1,108 @@
+// Package codec implements the wire encodings a WebSocket client can negotiate as a
+// subprotocol. Messages are built as JSON and transcoded by the connection's codec,
+// so handlers and broadcasts stay encoding-agnostic.
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec converts between the JSON form of a message and its wire encoding
+type Codec interface {
+	// Name is the subprotocol that selects this codec
+	Name() string
+	// MessageType is the WebSocket frame type used for encoded messages
+	MessageType() int
+	// FromJSON encodes a JSON message for the wire
+	FromJSON(data []byte) ([]byte, error)
+	// ToJSON decodes a wire message to JSON
+	ToJSON(data []byte) ([]byte, error)
+}
+
+var (
+	// JSON sends messages as JSON text frames; it is used when no codec is negotiated
+	JSON Codec = jsonCodec{}
+	// MsgPack sends messages as MessagePack binary frames with the same field names as JSON
+	MsgPack Codec = msgpackCodec{}
+)
+
+// Supported lists the negotiable codecs
+var Supported = []Codec{JSON, MsgPack}
+
+// ByName returns the codec for a subprotocol name
+func ByName(name string) (Codec, bool) {
+	for _, c := range Supported {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                         { return "json.v1" }
+func (jsonCodec) MessageType() int                     { return websocket.TextMessage }
+func (jsonCodec) FromJSON(data []byte) ([]byte, error) { return data, nil }
+func (jsonCodec) ToJSON(data []byte) ([]byte, error)   { return data, nil }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string     { return "msgpack.v1" }
+func (msgpackCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) FromJSON(data []byte) ([]byte, error) {
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) ToJSON(data []byte) ([]byte, error) {
+	var v any
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid msgpack message: %w", err)
+	}
+	return json.Marshal(v)
+}
+
+// decodeJSON decodes JSON keeping integers as int64, so binary encodings don't turn them into floats
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return convertNumbers(v), nil
+}
+
+func convertNumbers(v any) any {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]any:
+		for k, e := range t {
+			t[k] = convertNumbers(e)
+		}
+		return t
+	case []any:
+		for i, e := range t {
+			t[i] = convertNumbers(e)
+		}
+		return t
+	default:
+		return v
+	}
+}
@@ -1,9 +1,12 @@
 package wss
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/codec"
 	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
 )
 
@@ -19,6 +22,9 @@ type HandlerRegistration struct {
 	Middlewares []WsMiddleware
 }
 
+// ErrUnknownEvent is returned by Dispatch for events without a registered handler
+var ErrUnknownEvent = errors.New("unknown event type")
+
 type Dispatcher struct {
 	handlers map[string]*HandlerRegistration
 }
@@ -46,13 +52,25 @@ func (d *Dispatcher) RegisterWithMiddleware(event string, handler WsHandlerType,
 	}
 }
 
+// DecodeMessage decodes an inbound message encoded with the connection's codec
+func DecodeMessage(cd codec.Codec, data []byte) (wsstypes.WsMessageRequest, error) {
+	var wsMsg wsstypes.WsMessageRequest
+
+	raw, err := cd.ToJSON(data)
+	if err != nil {
+		return wsMsg, err
+	}
+	err = json.Unmarshal(raw, &wsMsg)
+	return wsMsg, err
+}
+
 func (d *Dispatcher) Dispatch(event string, ctx *wsstypes.WsContext) error {
 	log.Printf("dispatching event: %s", event)
 
 	registration, ok := d.handlers[event]
 	if !ok {
 		log.Printf("no handler found for event: %s", event)
-		return errors.New("unknown event type: " + event)
+		return fmt.Errorf("%w: %s", ErrUnknownEvent, event)
 	}
 
 	// Execute middleware chain before handler
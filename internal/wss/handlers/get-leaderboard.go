@@ -12,13 +12,6 @@ import (
 	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
 )
 
-type GetLeaderboardPayload struct {
-	UserId      string `json:"userId"`
-	Type        string `json:"type"`
-	ChallengeId string `json:"challengeId"`
-	Limit       int    `json:"limit,omitempty"` // Optional limit, defaults to 50
-}
-
 // NewGetLeaderboardHandler creates a handler with the leaderboard service dependency
 func NewGetLeaderboardHandler(leaderboardService *leaderboard.LeaderboardManager) func(*wsstypes.WsContext) error {
 	return func(ctx *wsstypes.WsContext) error {
@@ -29,29 +22,35 @@ func NewGetLeaderboardHandler(leaderboardService *leaderboard.LeaderboardManager
 // GetLeaderboardHandler is the default handler (for backward compatibility)
 func GetLeaderboardHandler(ctx *wsstypes.WsContext) error {
 	// This will fail if no leaderboard service is available
-	return broadcasts.SendErrorWithType(ctx.Conn, constants.CURRENT_LEADERBOARD, "Leaderboard service not configured", nil)
+	return broadcasts.ReplyError(ctx, constants.CURRENT_LEADERBOARD, wsstypes.ErrCodeUnavailable, "Leaderboard service not configured")
 }
 
 func getLeaderboardHandler(ctx *wsstypes.WsContext, leaderboardService *leaderboard.LeaderboardManager) error {
 	requestID := uuid.New().String()
 
-	var payload GetLeaderboardPayload
+	var payload wsstypes.GetLeaderboardPayload
 	raw, err := json.Marshal(ctx.Payload)
 	if err != nil {
 		log.Printf("[%s] [GetLeaderboard] Marshal error: %v", requestID, err)
-		return broadcasts.SendErrorWithType(ctx.Conn, constants.CURRENT_LEADERBOARD, "Internal error", nil)
+		return broadcasts.ReplyError(ctx, constants.CURRENT_LEADERBOARD, wsstypes.ErrCodeInternal, "Internal error")
 	}
 	if err := json.Unmarshal(raw, &payload); err != nil {
 		log.Printf("[%s] [GetLeaderboard] Unmarshal error: %v", requestID, err)
-		return broadcasts.SendErrorWithType(ctx.Conn, constants.CURRENT_LEADERBOARD, "Invalid payload format", nil)
+		return broadcasts.ReplyError(ctx, constants.CURRENT_LEADERBOARD, wsstypes.ErrCodeInvalidPayload, "Invalid payload format")
+	}
+
+	// Identity comes from the connection's session or token, never from the payload
+	userID, challengeID := ctx.Identity()
+	if challengeID == "" {
+		challengeID = payload.ChallengeId
 	}
 
-	log.Printf("[%s] [GetLeaderboard] Request from userId %s for challenge %s", requestID, payload.UserId, payload.ChallengeId)
+	log.Printf("[%s] [GetLeaderboard] Request from userId %s for challenge %s", requestID, userID, challengeID)
 
 	// Validate required fields
-	if payload.ChallengeId == "" {
+	if challengeID == "" {
 		log.Printf("[%s] [GetLeaderboard] Missing challengeId", requestID)
-		return broadcasts.SendErrorWithType(ctx.Conn, constants.CURRENT_LEADERBOARD, "Challenge ID is required", nil)
+		return broadcasts.ReplyError(ctx, constants.CURRENT_LEADERBOARD, wsstypes.ErrCodeInvalidPayload, "Challenge ID is required")
 	}
 
 	// Set default limit if not provided
@@ -61,35 +60,31 @@ func getLeaderboardHandler(ctx *wsstypes.WsContext, leaderboardService *leaderbo
 	}
 
 	// Verify challenge exists in Redis
-	challengeDoc, err := ctx.State.Redis.GetChallengeByID(context.Background(), payload.ChallengeId)
+	challengeDoc, err := ctx.State.Redis.GetChallengeByID(context.Background(), challengeID)
 	if err != nil {
 		log.Printf("[%s] [GetLeaderboard] Challenge not found in Redis: %v", requestID, err)
-		return broadcasts.SendErrorWithType(ctx.Conn, constants.CURRENT_LEADERBOARD, "Challenge not found", nil)
+		return broadcasts.ReplyError(ctx, constants.CURRENT_LEADERBOARD, wsstypes.ErrCodeNotFound, "Challenge not found")
 	}
 
 	// Check if user is participant (optional validation)
-	if payload.UserId != "" {
-		if _, exists := challengeDoc.Participants[payload.UserId]; !exists {
-			log.Printf("[%s] [GetLeaderboard] User %s is not a participant in challenge %s", requestID, payload.UserId, payload.ChallengeId)
-			return broadcasts.SendErrorWithType(ctx.Conn, constants.CURRENT_LEADERBOARD, "User is not a participant in this challenge", nil)
+	if userID != "" {
+		if _, exists := challengeDoc.Participants[userID]; !exists {
+			log.Printf("[%s] [GetLeaderboard] User %s is not a participant in challenge %s", requestID, userID, challengeID)
+			return broadcasts.ReplyError(ctx, constants.CURRENT_LEADERBOARD, wsstypes.ErrCodeNotJoined, "User is not a participant in this challenge")
 		}
 	}
 
 	// Get current leaderboard using the injected service
-	leaderboard, err := leaderboardService.GetLeaderboard(payload.ChallengeId, limit, &challengeDoc)
+	leaderboard, err := leaderboardService.GetLeaderboard(challengeID, limit, &challengeDoc)
 	if err != nil {
 		log.Printf("[%s] [GetLeaderboard] Failed to get leaderboard: %v", requestID, err)
-		return broadcasts.SendErrorWithType(ctx.Conn, constants.CURRENT_LEADERBOARD, "Failed to retrieve leaderboard", nil)
-	}
-
-	// Create response payload
-	response := map[string]interface{}{
-		"type":        constants.CURRENT_LEADERBOARD,
-		"challengeId": payload.ChallengeId,
-		"leaderboard": leaderboard,
+		return broadcasts.ReplyError(ctx, constants.CURRENT_LEADERBOARD, wsstypes.ErrCodeInternal, "Failed to retrieve leaderboard")
 	}
 
 	log.Printf("[%s] [GetLeaderboard] Sending leaderboard with %d entries", requestID, len(leaderboard))
 
-	return broadcasts.SendJSON(ctx.Conn, response)
+	return broadcasts.Reply(ctx, constants.CURRENT_LEADERBOARD, wsstypes.LeaderboardPayload{
+		ChallengeID: challengeID,
+		Leaderboard: leaderboard,
+	})
 }
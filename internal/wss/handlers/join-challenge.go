@@ -3,6 +3,7 @@ package wsshandler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -16,130 +17,185 @@ import (
 	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
 )
 
+// errChallengeFull aborts the join transaction when no participant slot is left
+var errChallengeFull = errors.New("challenge is full")
+
 type AuthPayload struct {
 	UserID string `json:"userId"`
 }
 
-func JoinChallengeHandler(ctx *wsstypes.WsContext) error {
-	requestID := uuid.New().String()
-	clientIP := ctx.Conn.RemoteAddr().String()
-
-	var payload wsstypes.JoinChallengePayload
-	raw, err := json.Marshal(ctx.Payload)
-	if err != nil {
-		log.Printf("[%s] [JoinChallenge] Marshal error: %v", requestID, err)
-		return broadcasts.SendErrorWithType(ctx.Conn, wsstypes.JOIN_CHALLENGE, "Internal error", nil)
-	}
-	if err := json.Unmarshal(raw, &payload); err != nil {
-		log.Printf("[%s] [JoinChallenge] Unmarshal error: %v", requestID, err)
-		return broadcasts.SendErrorWithType(ctx.Conn, wsstypes.JOIN_CHALLENGE, "Invalid payload format", nil)
-	}
-	log.Printf("[%s] [JoinChallenge] Incoming request from userId %s IP: %s", requestID, payload.UserId, clientIP)
-
-	fmt.Println("payload ", payload)
-
-	// auth
+// authenticateWithGateway validates a user token against the API gateway and returns the authenticated user.
+// Returned errors are safe to send to the client.
+func authenticateWithGateway(requestID, token string) (*AuthPayload, error) {
 	startAuth := time.Now()
 	req, err := http.NewRequestWithContext(context.Background(), "GET", config.LoadConfig().APIGatewayTokenCheckURL, nil)
 	if err != nil {
-		log.Printf("[%s] [JoinChallenge] Auth request create fail: %v", requestID, err)
-		return broadcasts.SendErrorWithType(ctx.Conn, wsstypes.JOIN_CHALLENGE, "Internal auth setup error", nil)
+		log.Printf("[%s] [Auth] Auth request create fail: %v", requestID, err)
+		return nil, errors.New("Internal auth setup error")
 	}
-	req.Header.Set("Authorization", payload.Token)
+	req.Header.Set("Authorization", token)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Printf("[%s] [JoinChallenge] Auth request failed: %v", requestID, err)
-		return broadcasts.SendErrorWithType(ctx.Conn, wsstypes.JOIN_CHALLENGE, "Authentication service unreachable", nil)
+		log.Printf("[%s] [Auth] Auth request failed: %v", requestID, err)
+		return nil, errors.New("Authentication service unreachable")
 	}
 	defer resp.Body.Close()
 
-	log.Printf("[%s] [JoinChallenge] Auth status: %d (took %v)", requestID, resp.StatusCode, time.Since(startAuth))
+	log.Printf("[%s] [Auth] Auth status: %d (took %v)", requestID, resp.StatusCode, time.Since(startAuth))
 
 	var authResp model.GenericResponse
 	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
-		log.Printf("[%s] [JoinChallenge] Decode auth response failed: %v", requestID, err)
-		return broadcasts.SendErrorWithType(ctx.Conn, wsstypes.JOIN_CHALLENGE, "Failed to decode authentication", nil)
+		log.Printf("[%s] [Auth] Decode auth response failed: %v", requestID, err)
+		return nil, errors.New("Failed to decode authentication")
 	}
 	if !authResp.Success {
-		log.Printf("[%s] [JoinChallenge] Auth failed: %v", requestID, authResp.Error)
-		return broadcasts.SendErrorWithType(ctx.Conn, wsstypes.JOIN_CHALLENGE, "Authentication failed", map[string]interface{}{
-			"error": authResp.Error,
-		})
+		log.Printf("[%s] [Auth] Auth failed: %v", requestID, authResp.Error)
+		return nil, errors.New("Authentication failed")
 	}
 
 	var userData AuthPayload
 	authPayloadRaw, _ := json.Marshal(authResp.Payload)
 	if err := json.Unmarshal(authPayloadRaw, &userData); err != nil {
-		log.Printf("[%s] [JoinChallenge] Invalid auth payload structure: %v", requestID, err)
-		return broadcasts.SendErrorWithType(ctx.Conn, wsstypes.JOIN_CHALLENGE, "Invalid auth data", nil)
+		log.Printf("[%s] [Auth] Invalid auth payload structure: %v", requestID, err)
+		return nil, errors.New("Invalid auth data")
+	}
+
+	return &userData, nil
+}
+
+func JoinChallengeHandler(ctx *wsstypes.WsContext) error {
+	requestID := uuid.New().String()
+	clientIP := ctx.Client.RemoteAddr().String()
+
+	var payload wsstypes.JoinChallengePayload
+	raw, err := json.Marshal(ctx.Payload)
+	if err != nil {
+		log.Printf("[%s] [JoinChallenge] Marshal error: %v", requestID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.JOIN_CHALLENGE, wsstypes.ErrCodeInternal, "Internal error")
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		log.Printf("[%s] [JoinChallenge] Unmarshal error: %v", requestID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.JOIN_CHALLENGE, wsstypes.ErrCodeInvalidPayload, "Invalid payload format")
+	}
+	log.Printf("[%s] [JoinChallenge] Incoming request from userId %s IP: %s", requestID, payload.UserId, clientIP)
+
+	fmt.Println("payload ", payload)
+
+	// auth
+	userData, err := authenticateWithGateway(requestID, payload.Token)
+	if err != nil {
+		log.Printf("[%s] [JoinChallenge] Auth failed: %v", requestID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.JOIN_CHALLENGE, wsstypes.ErrCodeAuth, err.Error())
 	}
 
 	log.Printf("[%s] [JoinChallenge] Authenticated user ID: %s", requestID, userData.UserID)
 
+	// Read the sequence before the snapshot so a RESUME from it can't miss events
+	seq, seqErr := ctx.State.Redis.LatestChallengeSeq(context.Background(), payload.ChallengeId)
+	if seqErr != nil {
+		log.Printf("[%s] [JoinChallenge] Failed to read event sequence: %v", requestID, seqErr)
+	}
+
 	// Load challenge from Redis
 	startRepoCheck := time.Now()
 	challengeDoc, err := ctx.State.Redis.GetChallengeByID(context.Background(), payload.ChallengeId)
 	if err != nil {
 		log.Printf("[%s] [JoinChallenge] Challenge not found in Redis: %v", requestID, err)
-		return broadcasts.SendErrorWithType(ctx.Conn, wsstypes.JOIN_CHALLENGE, "Challenge not found", nil)
+		return broadcasts.ReplyError(ctx, wsstypes.JOIN_CHALLENGE, wsstypes.ErrCodeNotFound, "Challenge not found")
 	}
 
 	if challengeDoc.Status == model.ChallengeAbandon {
-		return broadcasts.SendErrorWithType(ctx.Conn, wsstypes.JOIN_CHALLENGE, "Challenge is abandoned", nil)
+		return broadcasts.ReplyError(ctx, wsstypes.JOIN_CHALLENGE, wsstypes.ErrCodeChallengeClosed, "Challenge is abandoned")
 	}
 
 	// Check access (simplified - checking password if private)
 	if challengeDoc.IsPrivate && challengeDoc.Password != payload.Password {
 		log.Printf("[%s] [JoinChallenge] Access denied to challenge %s", requestID, payload.ChallengeId)
-		ctx.Conn.Close()
-		return broadcasts.SendErrorWithType(ctx.Conn, wsstypes.JOIN_CHALLENGE, "Invalid challenge ID or password", nil)
+		// Queued messages are flushed before the client closes
+		err := broadcasts.ReplyError(ctx, wsstypes.JOIN_CHALLENGE, wsstypes.ErrCodeForbidden, "Invalid challenge ID or password")
+		ctx.Client.Close()
+		return err
 	}
 
 	log.Printf("[%s] [JoinChallenge] Access granted for challenge %s (took %v)", requestID, payload.ChallengeId, time.Since(startRepoCheck))
 
-	// Add/update participant in Redis
-	participant, exists := challengeDoc.Participants[userData.UserID]
-	if !exists {
-		participant = &model.ParticipantMetadata{
-			ProblemsDone:  make(map[string]model.ChallengeProblemMetadata),
-			JoinTime:      time.Now().Unix(),
-			InitialJoinIP: clientIP,
+	// A connection acts as one user for its lifetime, so reject another user before joining
+	if err := ctx.Session.CheckClaim(userData.UserID, ""); err != nil {
+		log.Printf("[%s] [JoinChallenge] Session bound to another user than %s: %v", requestID, userData.UserID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.JOIN_CHALLENGE, wsstypes.ErrCodeForbidden, err.Error())
+	}
+
+	// Add or refresh the participant atomically, so concurrent joins can't exceed MaxUsers
+	rejoined := false
+	joined, err := ctx.State.Redis.MutateChallenge(context.Background(), payload.ChallengeId, func(c *model.ChallengeDocument) error {
+		if c.Participants == nil {
+			c.Participants = make(map[string]*model.ParticipantMetadata)
 		}
-		err := ctx.State.Redis.AddParticipant(context.Background(), payload.ChallengeId, userData.UserID, participant)
-		if err != nil {
-			log.Printf("[%s] [JoinChallenge] Failed to persist participant: %v", requestID, err)
+		participant, exists := c.Participants[userData.UserID]
+		if !exists {
+			if c.Config != nil && c.Config.MaxUsers > 0 && len(c.Participants) >= c.Config.MaxUsers {
+				return errChallengeFull
+			}
+			participant = &model.ParticipantMetadata{
+				ProblemsDone:  make(map[string]model.ChallengeProblemMetadata),
+				JoinTime:      time.Now().Unix(),
+				InitialJoinIP: clientIP,
+			}
+			c.Participants[userData.UserID] = participant
 		}
-		log.Printf("[%s] [JoinChallenge] New participant %s added", requestID, userData.UserID)
-	} else {
-		log.Printf("[%s] [JoinChallenge] Participant %s rejoined", requestID, userData.UserID)
+		rejoined = exists
+		participant.LastConnected = time.Now().Unix()
+		return nil
+	})
+	if errors.Is(err, errChallengeFull) {
+		log.Printf("[%s] [JoinChallenge] Challenge %s is full", requestID, payload.ChallengeId)
+		return broadcasts.ReplyError(ctx, wsstypes.JOIN_CHALLENGE, wsstypes.ErrCodeChallengeFull, "Challenge is full")
 	}
-	participant.LastConnected = time.Now().Unix()
-
-	// Update participant in Redis
-	err = ctx.State.Redis.UpdateParticipant(context.Background(), payload.ChallengeId, userData.UserID, participant)
 	if err != nil {
-		log.Printf("[%s] [JoinChallenge] Failed to update participant: %v", requestID, err)
+		log.Printf("[%s] [JoinChallenge] Failed to persist participant: %v", requestID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.JOIN_CHALLENGE, wsstypes.ErrCodeInternal, "Failed to join challenge")
 	}
+	challengeDoc = *joined
 
-	// Add WebSocket connection to local state
-	ctx.State.LocalState.AddWSClient(payload.ChallengeId, userData.UserID, ctx.Conn)
+	// Bind only once the participant is persisted, so a failed join leaves the connection as it was.
+	// Later messages act as this identity; a connection in another challenge moves here.
+	if _, err := ctx.Session.Bind(userData.UserID, payload.ChallengeId); err != nil {
+		log.Printf("[%s] [JoinChallenge] Session bind failed for user %s: %v", requestID, userData.UserID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.JOIN_CHALLENGE, wsstypes.ErrCodeForbidden, err.Error())
+	}
+	if rejoined {
+		log.Printf("[%s] [JoinChallenge] Participant %s rejoined", requestID, userData.UserID)
+	} else {
+		log.Printf("[%s] [JoinChallenge] New participant %s added", requestID, userData.UserID)
+	}
 
-	// Get all WebSocket clients for broadcasting
-	wsClients := ctx.State.LocalState.GetAllWSClients(payload.ChallengeId)
-	broadcasts.BroadcastEntityJoinedWithClients(wsClients, userData.UserID, payload.ChallengeId, userData.UserID == challengeDoc.CreatorID)
+	// Add WebSocket connection to local state; further tabs or devices of a connected user join silently
+	first := ctx.State.LocalState.AddWSClient(payload.ChallengeId, userData.UserID, ctx.Client)
+
+	// The user may already be connected through another node
+	if ctx.State.Presence != nil {
+		online, err := ctx.State.Presence.Connect(context.Background(), payload.ChallengeId, userData.UserID, ctx.Client.ID())
+		if err != nil {
+			log.Printf("[%s] [JoinChallenge] Failed to record presence, using local state: %v", requestID, err)
+		} else {
+			first = online
+		}
+	}
+
+	if first {
+		broadcasts.BroadcastEntityJoined(ctx.State.Broadcaster, ctx.State.Redis, userData.UserID, payload.ChallengeId, userData.UserID == challengeDoc.CreatorID)
+	} else {
+		log.Printf("[%s] [JoinChallenge] User %s opened another connection %s", requestID, userData.UserID, ctx.Client.ID())
+	}
 
-	newToken, _ := ctx.State.JwtManager.GenerateToken(payload.UserId, payload.ChallengeId, time.Duration(challengeDoc.TimeLimit)+constants.BufferTime)
+	newToken, _ := ctx.State.JwtManager.GenerateToken(userData.UserID, payload.ChallengeId, time.Duration(challengeDoc.TimeLimit)+constants.BufferTime)
 
-	return broadcasts.SendJSON(ctx.Conn, map[string]interface{}{
-		"type":    wsstypes.JOIN_CHALLENGE,
-		"status":  "success",
-		"message": "Joined challenge successfully",
-		"payload": map[string]interface{}{
-			"userId":      userData.UserID,
-			"challengeId": payload.ChallengeId,
-			"challenge":   challengeDoc,
-			"token":       newToken,
-		},
+	return broadcasts.Reply(ctx, wsstypes.JOIN_CHALLENGE, wsstypes.ChallengeStatePayload{
+		UserID:      userData.UserID,
+		ChallengeID: payload.ChallengeId,
+		Challenge:   challengeDoc,
+		Seq:         seq,
+		Token:       newToken,
 	})
 }
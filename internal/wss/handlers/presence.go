@@ -0,0 +1,49 @@
+package wsshandler
+
+import (
+	"context"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/broadcasts"
+	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
+)
+
+// PresenceHandler replies with the users connected to the joined challenge on any node,
+// with their connection IDs, nodes and last heartbeats. Requires JWT middleware.
+func PresenceHandler(ctx *wsstypes.WsContext) error {
+	requestID := uuid.New().String()
+
+	userID, challengeID := ctx.Identity()
+	if challengeID == "" {
+		return broadcasts.ReplyError(ctx, wsstypes.PRESENCE, wsstypes.ErrCodeNotJoined, "User not joined to this challenge")
+	}
+
+	if ctx.State.Presence == nil {
+		return broadcasts.ReplyError(ctx, wsstypes.PRESENCE, wsstypes.ErrCodeUnavailable, "Presence is not available")
+	}
+
+	users, err := ctx.State.Presence.Online(context.Background(), challengeID)
+	if err != nil {
+		log.Printf("[%s] [Presence] Failed to get presence of challenge %s for user %s: %v", requestID, challengeID, userID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.PRESENCE, wsstypes.ErrCodeUnavailable, "Failed to get presence")
+	}
+
+	return broadcasts.Reply(ctx, wsstypes.PRESENCE, wsstypes.PresenceResult{
+		ChallengeID: challengeID,
+		Users:       users,
+	})
+}
+
+// isUserJoined reports whether the user is connected to the challenge on any node,
+// falling back to this node's connections if the presence registry is unavailable
+func isUserJoined(ctx *wsstypes.WsContext, challengeID, userID string) bool {
+	if ctx.State.Presence != nil {
+		online, err := ctx.State.Presence.IsOnline(context.Background(), challengeID, userID)
+		if err == nil {
+			return online
+		}
+		log.Printf("[Presence] Failed to check presence of user %s in challenge %s, using local state: %v", userID, challengeID, err)
+	}
+	return ctx.State.LocalState.IsUserConnected(challengeID, userID)
+}
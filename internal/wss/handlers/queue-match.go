@@ -0,0 +1,83 @@
+package wsshandler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/matchmaking"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/rating"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/broadcasts"
+	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
+)
+
+// QueueMatchHandler authenticates the user and places them in the matchmaking queue.
+// A MATCH_FOUND event is pushed to the connection once a match is made.
+func QueueMatchHandler(ctx *wsstypes.WsContext) error {
+	requestID := uuid.New().String()
+
+	var payload wsstypes.QueueMatchPayload
+	raw, err := json.Marshal(ctx.Payload)
+	if err != nil {
+		log.Printf("[%s] [QueueMatch] Marshal error: %v", requestID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.QUEUE_MATCH, wsstypes.ErrCodeInternal, "Internal error")
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		log.Printf("[%s] [QueueMatch] Unmarshal error: %v", requestID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.QUEUE_MATCH, wsstypes.ErrCodeInvalidPayload, "Invalid payload format")
+	}
+
+	if ctx.State.Matchmaker == nil {
+		return broadcasts.ReplyError(ctx, wsstypes.QUEUE_MATCH, wsstypes.ErrCodeUnavailable, "Matchmaking not available")
+	}
+
+	// auth
+	userData, err := authenticateWithGateway(requestID, payload.Token)
+	if err != nil {
+		log.Printf("[%s] [QueueMatch] Auth failed: %v", requestID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.QUEUE_MATCH, wsstypes.ErrCodeAuth, err.Error())
+	}
+
+	// A connection bound to one user can't queue as another
+	if ctx.Session != nil {
+		if err := ctx.Session.CheckClaim(userData.UserID, ""); err != nil {
+			log.Printf("[%s] [QueueMatch] Rejected user %s: %v", requestID, userData.UserID, err)
+			return broadcasts.ReplyError(ctx, wsstypes.QUEUE_MATCH, wsstypes.ErrCodeForbidden, err.Error())
+		}
+	}
+
+	// Rating is used for rating-band matching; new users start at the default
+	userRating := rating.DefaultRating
+	if ctx.State.Mongo != nil {
+		if ratings, err := ctx.State.Mongo.GetUserRatings(context.Background(), []string{userData.UserID}); err != nil {
+			log.Printf("[%s] [QueueMatch] Failed to get rating for user %s: %v", requestID, userData.UserID, err)
+		} else if r, exists := ratings[userData.UserID]; exists {
+			userRating = r.Rating
+		}
+	}
+
+	queued, err := ctx.State.Matchmaker.Enqueue(&matchmaking.Ticket{
+		UserID: userData.UserID,
+		Client: ctx.Client,
+		Preferences: matchmaking.Preferences{
+			Size:               payload.Size,
+			MaxEasyQuestions:   payload.MaxEasyQuestions,
+			MaxMediumQuestions: payload.MaxMediumQuestions,
+			MaxHardQuestions:   payload.MaxHardQuestions,
+		},
+		Rating: userRating,
+	})
+	if err != nil {
+		log.Printf("[%s] [QueueMatch] Enqueue failed for user %s: %v", requestID, userData.UserID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.QUEUE_MATCH, wsstypes.ErrCodeInvalidPayload, err.Error())
+	}
+
+	log.Printf("[%s] [QueueMatch] User %s queued (rating %.0f, %d waiting)", requestID, userData.UserID, userRating, queued)
+
+	return broadcasts.Reply(ctx, wsstypes.QUEUE_MATCH, wsstypes.QueueMatchResult{
+		UserID:  userData.UserID,
+		Rating:  userRating,
+		Waiting: queued,
+	})
+}
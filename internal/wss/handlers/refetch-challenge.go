@@ -17,66 +17,46 @@ func RetreiveChallenge(ctx *wsstypes.WsContext) error {
 	raw, err := json.Marshal(ctx.Payload)
 	if err != nil {
 		log.Printf("[%s] [RetreiveChallenge] Marshal error: %v", requestID, err)
-		return broadcasts.SendJSON(ctx.Conn, map[string]interface{}{
-			"type":   wsstypes.RETRIEVE_CHALLENGE,
-			"status": "error",
-			"error": map[string]interface{}{
-				"code":    "MARSHAL_ERROR",
-				"message": "Internal server error",
-			},
-		})
+		return broadcasts.ReplyError(ctx, wsstypes.RETRIEVE_CHALLENGE, wsstypes.ErrCodeInternal, "Internal server error")
 	}
 
 	if err := json.Unmarshal(raw, &payload); err != nil {
 		log.Printf("[%s] [RetreiveChallenge] Unmarshal error: %v", requestID, err)
-		return broadcasts.SendJSON(ctx.Conn, map[string]interface{}{
-			"type":   wsstypes.RETRIEVE_CHALLENGE,
-			"status": "error",
-			"error": map[string]interface{}{
-				"code":    "INVALID_PAYLOAD",
-				"message": "Payload format invalid",
-			},
-		})
+		return broadcasts.ReplyError(ctx, wsstypes.RETRIEVE_CHALLENGE, wsstypes.ErrCodeInvalidPayload, "Payload format invalid")
+	}
+
+	// Identity comes from the connection's session or token, never from the payload
+	userID, challengeID := ctx.Identity()
+	if userID == "" || challengeID == "" {
+		log.Printf("[%s] [RetreiveChallenge] No authenticated identity on connection", requestID)
+		return broadcasts.ReplyError(ctx, wsstypes.RETRIEVE_CHALLENGE, wsstypes.ErrCodeNotJoined, "User not joined to this challenge")
+	}
+
+	// Read the sequence before the snapshot so a RESUME from it can't miss events
+	seq, seqErr := ctx.State.Redis.LatestChallengeSeq(context.Background(), challengeID)
+	if seqErr != nil {
+		log.Printf("[%s] [RetreiveChallenge] Failed to read event sequence: %v", requestID, seqErr)
 	}
 
 	// Load challenge from Redis
-	challengeDoc, err := ctx.State.Redis.GetChallengeByID(context.Background(), payload.ChallengeId)
+	challengeDoc, err := ctx.State.Redis.GetChallengeByID(context.Background(), challengeID)
 	if err != nil {
-		log.Printf("[%s] [RetreiveChallenge] Challenge %s not found in Redis: %v", requestID, payload.ChallengeId, err)
-		return broadcasts.SendJSON(ctx.Conn, map[string]interface{}{
-			"type":   wsstypes.RETRIEVE_CHALLENGE,
-			"status": "error",
-			"error": map[string]interface{}{
-				"code":    "CHALLENGE_NOT_FOUND",
-				"message": "Challenge not found or not joined",
-			},
-		})
+		log.Printf("[%s] [RetreiveChallenge] Challenge %s not found in Redis: %v", requestID, challengeID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.RETRIEVE_CHALLENGE, wsstypes.ErrCodeNotFound, "Challenge not found or not joined")
 	}
 
 	// Check if user has WebSocket connection (is joined)
-	_, hasConnection := ctx.State.LocalState.GetWSClient(payload.ChallengeId, payload.UserId)
-	if !hasConnection {
-		log.Printf("[%s] [RetreiveChallenge] User %s not connected to challenge %s", requestID, payload.UserId, payload.ChallengeId)
-		return broadcasts.SendJSON(ctx.Conn, map[string]interface{}{
-			"type":   wsstypes.RETRIEVE_CHALLENGE,
-			"status": "error",
-			"error": map[string]interface{}{
-				"code":    "NOT_JOINED",
-				"message": "User not joined to this challenge",
-			},
-		})
+	if !isUserJoined(ctx, challengeID, userID) {
+		log.Printf("[%s] [RetreiveChallenge] User %s not connected to challenge %s", requestID, userID, challengeID)
+		return broadcasts.ReplyError(ctx, wsstypes.RETRIEVE_CHALLENGE, wsstypes.ErrCodeNotJoined, "User not joined to this challenge")
 	}
 
-	log.Printf("[%s] [RetreiveChallenge] Sending latest challenge state to user %s", requestID, payload.UserId)
+	log.Printf("[%s] [RetreiveChallenge] Sending latest challenge state to user %s", requestID, userID)
 
-	return broadcasts.SendJSON(ctx.Conn, map[string]interface{}{
-		"type":    wsstypes.RETRIEVE_CHALLENGE,
-		"status":  "ok",
-		"message": "Challenge state fetched successfully",
-		"payload": map[string]interface{}{
-			"userId":      payload.UserId,
-			"challengeId": payload.ChallengeId,
-			"challenge":   challengeDoc,
-		},
+	return broadcasts.Reply(ctx, wsstypes.RETRIEVE_CHALLENGE, wsstypes.ChallengeStatePayload{
+		UserID:      userID,
+		ChallengeID: challengeID,
+		Challenge:   challengeDoc,
+		Seq:         seq,
 	})
 }
@@ -0,0 +1,105 @@
+package wsshandler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/rematch"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/broadcasts"
+	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
+)
+
+// RematchRequestHandler starts a rematch of the challenge in the caller's token and
+// asks the other participants to accept. Requires JWT middleware.
+func RematchRequestHandler(ctx *wsstypes.WsContext) error {
+	requestID := uuid.New().String()
+
+	if ctx.State.Rematches == nil {
+		return broadcasts.ReplyError(ctx, wsstypes.REMATCH_REQUEST, wsstypes.ErrCodeUnavailable, "Rematch not available")
+	}
+
+	previous, code, err := loadEndedChallenge(ctx, ctx.Claims.ChallengeID)
+	if err != nil {
+		log.Printf("[%s] [RematchRequest] Cannot rematch challenge %s: %v", requestID, ctx.Claims.ChallengeID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.REMATCH_REQUEST, code, err.Error())
+	}
+
+	pending, ready, err := ctx.State.Rematches.Request(previous, ctx.Claims.UserID, ctx.Client)
+	if err != nil {
+		log.Printf("[%s] [RematchRequest] Request failed for user %s: %v", requestID, ctx.Claims.UserID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.REMATCH_REQUEST, wsstypes.ErrCodeConflict, err.Error())
+	}
+
+	log.Printf("[%s] [RematchRequest] User %s requested rematch of challenge %s", requestID, ctx.Claims.UserID, previous.ChallengeID)
+
+	broadcastRematchStatus(ctx, wsstypes.REMATCH_REQUEST, pending, ready)
+	return nil
+}
+
+// RematchAcceptHandler accepts a pending rematch of the challenge in the caller's token.
+// Requires JWT middleware.
+func RematchAcceptHandler(ctx *wsstypes.WsContext) error {
+	requestID := uuid.New().String()
+
+	if ctx.State.Rematches == nil {
+		return broadcasts.ReplyError(ctx, wsstypes.REMATCH_ACCEPT, wsstypes.ErrCodeUnavailable, "Rematch not available")
+	}
+
+	pending, ready, err := ctx.State.Rematches.Accept(ctx.Claims.ChallengeID, ctx.Claims.UserID, ctx.Client)
+	if err != nil {
+		log.Printf("[%s] [RematchAccept] Accept failed for user %s: %v", requestID, ctx.Claims.UserID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.REMATCH_ACCEPT, wsstypes.ErrCodeConflict, err.Error())
+	}
+
+	log.Printf("[%s] [RematchAccept] User %s accepted rematch of challenge %s (%d/%d)", requestID, ctx.Claims.UserID, ctx.Claims.ChallengeID, len(pending.Accepted), len(pending.Required))
+
+	broadcastRematchStatus(ctx, wsstypes.REMATCH_ACCEPT, pending, ready)
+	return nil
+}
+
+// loadEndedChallenge loads a finished challenge from MongoDB, falling back to Redis
+// for challenges that haven't been persisted yet. On failure it also returns the
+// error code to reply with.
+func loadEndedChallenge(ctx *wsstypes.WsContext, challengeID string) (*model.ChallengeDocument, wsstypes.ErrorCode, error) {
+	if challengeID == "" {
+		return nil, wsstypes.ErrCodeInvalidPayload, errors.New("challenge ID is required")
+	}
+
+	challengeDoc, err := ctx.State.Mongo.GetChallengeByID(context.Background(), challengeID)
+	if err != nil {
+		challengeDoc, err = ctx.State.Redis.GetChallengeByID(context.Background(), challengeID)
+		if err != nil {
+			return nil, wsstypes.ErrCodeNotFound, errors.New("challenge not found")
+		}
+	}
+
+	if challengeDoc.Status != model.ChallengeEnded {
+		return nil, wsstypes.ErrCodeConflict, errors.New("challenge has not ended")
+	}
+
+	return &challengeDoc, "", nil
+}
+
+// broadcastRematchStatus sends the rematch progress to every prior participant still connected
+func broadcastRematchStatus(ctx *wsstypes.WsContext, eventType string, pending *rematch.Rematch, ready bool) {
+	wsClients := ctx.State.LocalState.GetAllWSClients(pending.PreviousChallenge.ChallengeID)
+	for _, c := range pending.Accepted {
+		wsClients[c.ID()] = c
+	}
+
+	payload := wsstypes.RematchStatusPayload{
+		ChallengeID: pending.PreviousChallenge.ChallengeID,
+		RequestedBy: pending.RequestedBy,
+		Accepted:    pending.AcceptedUserIDs(),
+		Required:    pending.RequiredUserIDs(),
+		Ready:       ready,
+		ExpiresAt:   pending.CreatedAt.Add(rematch.RematchTimeout),
+		Time:        time.Now(),
+	}
+
+	broadcasts.BroadcastStandardSuccess(wsClients, eventType, payload)
+}
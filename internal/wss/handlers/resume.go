@@ -0,0 +1,97 @@
+package wsshandler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/broadcasts"
+	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
+)
+
+// ResumeHandler re-registers a reconnected participant's connection for the challenge's
+// broadcasts, replays the ones it missed after its last seen sequence number, then
+// replies with RESUME. If the gap has been trimmed from the event
+// stream, or is too large to queue at once, nothing is replayed and the reply asks the
+// client to refetch a full snapshot. Requires JWT middleware.
+func ResumeHandler(ctx *wsstypes.WsContext) error {
+	requestID := uuid.New().String()
+
+	var payload wsstypes.ResumePayload
+	raw, err := json.Marshal(ctx.Payload)
+	if err != nil {
+		log.Printf("[%s] [Resume] Marshal error: %v", requestID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.RESUME, wsstypes.ErrCodeInternal, "Internal error")
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		log.Printf("[%s] [Resume] Unmarshal error: %v", requestID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.RESUME, wsstypes.ErrCodeInvalidPayload, "Invalid payload format")
+	}
+
+	userID, challengeID := ctx.Identity()
+	if challengeID == "" {
+		return broadcasts.ReplyError(ctx, wsstypes.RESUME, wsstypes.ErrCodeNotJoined, "User not joined to this challenge")
+	}
+
+	challengeDoc, err := ctx.State.Redis.GetChallengeByID(context.Background(), challengeID)
+	if err != nil {
+		log.Printf("[%s] [Resume] Challenge %s not found: %v", requestID, challengeID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.RESUME, wsstypes.ErrCodeNotFound, "Challenge not found")
+	}
+	if _, ok := challengeDoc.Participants[userID]; !ok {
+		return broadcasts.ReplyError(ctx, wsstypes.RESUME, wsstypes.ErrCodeNotJoined, "User is not a participant in this challenge")
+	}
+
+	// A reconnected socket may only carry a token; bind it so disconnect cleanup applies
+	if _, err := ctx.Session.Bind(userID, challengeID); err != nil {
+		log.Printf("[%s] [Resume] Session bind failed for user %s: %v", requestID, userID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.RESUME, wsstypes.ErrCodeForbidden, err.Error())
+	}
+
+	// Register for live broadcasts before reading the backlog, so no event falls between
+	// the two; one arriving both ways is skipped by the client by its seq
+	first := ctx.State.LocalState.AddWSClient(challengeID, userID, ctx.Client)
+	if ctx.State.Presence != nil {
+		online, err := ctx.State.Presence.Connect(context.Background(), challengeID, userID, ctx.Client.ID())
+		if err != nil {
+			log.Printf("[%s] [Resume] Failed to record presence, using local state: %v", requestID, err)
+		} else {
+			first = online
+		}
+	}
+	if first {
+		broadcasts.BroadcastEntityJoined(ctx.State.Broadcaster, ctx.State.Redis, userID, challengeID, userID == challengeDoc.CreatorID)
+	}
+
+	events, latest, complete, err := ctx.State.Redis.ChallengeEventsSince(context.Background(), challengeID, payload.LastSeq)
+	if err != nil {
+		log.Printf("[%s] [Resume] Failed to read events for challenge %s: %v", requestID, challengeID, err)
+		return broadcasts.ReplyError(ctx, wsstypes.RESUME, wsstypes.ErrCodeInternal, "Failed to read challenge events")
+	}
+
+	result := wsstypes.ResumeResult{
+		ChallengeID: challengeID,
+		FromSeq:     payload.LastSeq,
+		LatestSeq:   latest,
+	}
+
+	// Leave room in the send queue so a replay never trips slow-consumer eviction
+	if !complete || len(events) > ctx.Client.QueueSize()/2 {
+		log.Printf("[%s] [Resume] User %s needs a snapshot of challenge %s (from %d, latest %d, %d events)", requestID, userID, challengeID, payload.LastSeq, latest, len(events))
+		result.SnapshotRequired = true
+		return broadcasts.Reply(ctx, wsstypes.RESUME, result)
+	}
+
+	for _, event := range events {
+		if err := broadcasts.ReplayChallengeEvent(ctx.Client, event); err != nil {
+			log.Printf("[%s] [Resume] Replay of seq %d failed for user %s: %v", requestID, event.Seq, userID, err)
+			return err
+		}
+		result.Replayed++
+	}
+
+	log.Printf("[%s] [Resume] Replayed %d events of challenge %s to user %s", requestID, result.Replayed, challengeID, userID)
+
+	return broadcasts.Reply(ctx, wsstypes.RESUME, result)
+}
@@ -3,8 +3,10 @@ package middleware
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/lijuuu/ChallengeWssManagerService/internal/jwt"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/broadcasts"
@@ -89,9 +91,7 @@ func (m *AuthMiddleware) AuthorizeJoinChallenge(ctx *wsstypes.WsContext, token,
 
 // SendAuthError sends a standardized authentication error response
 func (m *AuthMiddleware) SendAuthError(ctx *wsstypes.WsContext, messageType, errorMsg string) error {
-	return broadcasts.SendErrorWithType(ctx.Conn, messageType, errorMsg, map[string]any{
-		"authError": true,
-	})
+	return broadcasts.ReplyError(ctx, messageType, wsstypes.ErrCodeAuth, errorMsg)
 }
 
 // JWTMiddleware creates a middleware function for JWT verification
@@ -105,14 +105,34 @@ func (m *AuthMiddleware) JWTMiddleware() func(*wsstypes.WsContext) error {
 			}
 		}
 
+		// Connections authenticated at upgrade need no per-message token
+		if token == "" && ctx.Claims != nil {
+			if exp := ctx.Claims.ExpiresAt; exp != nil && time.Now().After(exp.Time) {
+				broadcasts.ReplyError(ctx, ctx.EventType, wsstypes.ErrCodeAuth, "Invalid or expired token")
+				return errors.New("connection token expired")
+			}
+			ctx.UserID = ctx.Claims.UserID
+			return nil
+		}
+
 		if token == "" {
-			return broadcasts.SendErrorWithType(ctx.Conn, "AUTH_ERROR", "Authentication token required", nil)
+			broadcasts.ReplyError(ctx, ctx.EventType, wsstypes.ErrCodeAuth, "Authentication token required")
+			return errors.New("authentication token required")
 		}
 
 		// Validate JWT token
 		claims, err := m.jwtManager.ValidateToken(token)
 		if err != nil {
-			return broadcasts.SendErrorWithType(ctx.Conn, "AUTH_ERROR", "Invalid or expired token", nil)
+			broadcasts.ReplyError(ctx, ctx.EventType, wsstypes.ErrCodeAuth, "Invalid or expired token")
+			return fmt.Errorf("invalid or expired token: %w", err)
+		}
+
+		// Token must belong to the identity bound to this connection
+		if ctx.Session != nil {
+			if err := ctx.Session.CheckClaim(claims.UserID, claims.ChallengeID); err != nil {
+				broadcasts.ReplyError(ctx, ctx.EventType, wsstypes.ErrCodeAuth, "Token does not match this connection")
+				return err
+			}
 		}
 
 		// Store claims in context for handler use
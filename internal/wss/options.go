@@ -0,0 +1,68 @@
+package wss
+
+import (
+	"compress/flate"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/config"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/client"
+)
+
+// Options configures WebSocket connections accepted by WsHandler
+type Options struct {
+	ClientPolicy   client.Policy
+	PongWait       time.Duration // read deadline, refreshed on every pong
+	MaxMessageSize int64         // max inbound message size in bytes
+	Origins        OriginPolicy
+}
+
+// OptionsFromConfig builds WebSocket options from the service config. It fails if the
+// origin policy is unusable, so a misconfigured deployment does not start.
+func OptionsFromConfig(cfg *config.Config) (Options, error) {
+	opts := Options{
+		ClientPolicy: client.Policy{
+			QueueSize:    cfg.WSSendQueueSize,
+			WriteWait:    time.Duration(cfg.WSWriteTimeoutSeconds) * time.Second,
+			MaxLag:       time.Duration(cfg.WSMaxLagSeconds) * time.Second,
+			PingInterval: time.Duration(cfg.WSPingIntervalSeconds) * time.Second,
+			Compression: client.Compression{
+				Enabled:   cfg.WSCompressionEnabled,
+				Level:     cfg.WSCompressionLevel,
+				Threshold: cfg.WSCompressionThreshold,
+			},
+		},
+		PongWait:       time.Duration(cfg.WSPongWaitSeconds) * time.Second,
+		MaxMessageSize: cfg.WSMaxMessageBytes,
+		Origins: OriginPolicy{
+			Allowed: cfg.WSAllowedOrigins,
+			DevMode: cfg.WSOriginDevMode,
+		},
+	}
+
+	if err := opts.Origins.Validate(); err != nil {
+		return Options{}, fmt.Errorf("invalid origin policy: %w", err)
+	}
+	if opts.Origins.DevMode {
+		log.Println("[WS] origin dev mode enabled: accepting WebSocket upgrades from any origin")
+	}
+
+	if c := &opts.ClientPolicy.Compression; c.Enabled && (c.Level < flate.BestSpeed || c.Level > flate.BestCompression) {
+		log.Printf("[WS] compression level %d out of range, using %d", c.Level, client.DefaultCompression.Level)
+		c.Level = client.DefaultCompression.Level
+	}
+
+	if opts.PongWait <= 0 {
+		opts.PongWait = model.WebsocketReadTimeout
+	}
+
+	// Pings must arrive before the read deadline expires
+	if opts.ClientPolicy.PingInterval <= 0 || opts.ClientPolicy.PingInterval >= opts.PongWait {
+		opts.ClientPolicy.PingInterval = opts.PongWait * 9 / 10
+		log.Printf("[WS] ping interval adjusted to %v to stay within pong wait %v", opts.ClientPolicy.PingInterval, opts.PongWait)
+	}
+
+	return opts, nil
+}
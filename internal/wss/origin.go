@@ -0,0 +1,88 @@
+package wss
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var rejectedOrigins = expvar.NewInt("ws_rejected_origins")
+
+// OriginPolicy decides which browser origins may open WebSocket connections.
+//
+// Allowed entries are either exact origins ("https://app.example.com") or wildcard
+// subdomains ("https://*.example.com", which matches any subdomain but not the apex),
+// and always include the scheme. DevMode accepts every origin; otherwise the list must
+// not be empty, which Validate enforces at startup.
+type OriginPolicy struct {
+	Allowed []string
+	DevMode bool
+}
+
+// Check reports whether the upgrade request's origin is allowed. Requests without
+// an Origin header come from non-browser clients and are always allowed.
+func (p OriginPolicy) Check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.DevMode {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return p.reject(r, origin)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+
+	for _, allowed := range p.Allowed {
+		if originMatches(allowed, scheme, host) {
+			return true
+		}
+	}
+	return p.reject(r, origin)
+}
+
+// Validate rejects a policy that would refuse every browser or match too loosely:
+// an empty allow-list outside dev mode, or an entry without a scheme
+func (p OriginPolicy) Validate() error {
+	if p.DevMode {
+		return nil
+	}
+	if len(p.Allowed) == 0 {
+		return errors.New("no allowed origins configured; set WSALLOWEDORIGINS or enable WSORIGINDEVMODE")
+	}
+	for _, allowed := range p.Allowed {
+		if scheme, host, ok := strings.Cut(strings.TrimSpace(allowed), "://"); !ok || scheme == "" || host == "" {
+			return fmt.Errorf("allowed origin %q must be scheme and host, e.g. https://app.example.com", allowed)
+		}
+	}
+	return nil
+}
+
+func (p OriginPolicy) reject(r *http.Request, origin string) bool {
+	rejectedOrigins.Add(1)
+	log.Printf("[WS] rejected origin %q from %s", origin, r.RemoteAddr)
+	return false
+}
+
+// originMatches compares one allow-list entry against a parsed origin
+func originMatches(allowed, scheme, host string) bool {
+	allowed = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(allowed)), "/")
+	if allowed == "" {
+		return false
+	}
+
+	allowedScheme, allowed, ok := strings.Cut(allowed, "://")
+	if !ok || allowedScheme != scheme {
+		return false
+	}
+
+	if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return allowed == host
+}
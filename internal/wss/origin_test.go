@@ -0,0 +1,90 @@
+package wss
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		scheme  string
+		host    string
+		want    bool
+	}{
+		{"exact origin", "https://app.example.com", "https", "app.example.com", true},
+		{"case and trailing slash are ignored", " HTTPS://App.Example.com/ ", "https", "app.example.com", true},
+		{"other host", "https://app.example.com", "https", "evil.com", false},
+		{"port must match", "https://app.example.com", "https", "app.example.com:8443", false},
+		{"scheme mismatch", "https://app.example.com", "http", "app.example.com", false},
+		{"entry without scheme", "app.example.com", "https", "app.example.com", false},
+		{"wildcard matches a subdomain", "https://*.example.com", "https", "a.example.com", true},
+		{"wildcard matches nested subdomains", "https://*.example.com", "https", "a.b.example.com", true},
+		{"wildcard rejects the apex", "https://*.example.com", "https", "example.com", false},
+		{"wildcard rejects lookalike domains", "https://*.example.com", "https", "badexample.com", false},
+		{"wildcard scheme mismatch", "https://*.example.com", "http", "a.example.com", false},
+		{"empty entry", "", "https", "app.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := originMatches(tt.allowed, tt.scheme, tt.host); got != tt.want {
+				t.Errorf("originMatches(%q, %q, %q) = %v, want %v", tt.allowed, tt.scheme, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOriginPolicyCheck(t *testing.T) {
+	policy := OriginPolicy{Allowed: []string{"https://app.example.com", "https://*.example.org"}}
+
+	tests := []struct {
+		name   string
+		policy OriginPolicy
+		origin string
+		want   bool
+	}{
+		{"no origin header", policy, "", true},
+		{"allowed origin", policy, "https://app.example.com", true},
+		{"wildcard origin", policy, "https://a.b.example.org", true},
+		{"apex of wildcard", policy, "https://example.org", false},
+		{"scheme mismatch", policy, "http://app.example.com", false},
+		{"unparseable origin", policy, "null", false},
+		{"dev mode allows anything", OriginPolicy{DevMode: true}, "http://localhost:3000", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := tt.policy.Check(r); got != tt.want {
+				t.Errorf("Check(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOriginPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  OriginPolicy
+		wantErr bool
+	}{
+		{"dev mode without origins", OriginPolicy{DevMode: true}, false},
+		{"no origins", OriginPolicy{}, true},
+		{"origins with schemes", OriginPolicy{Allowed: []string{"https://app.example.com", "https://*.example.com"}}, false},
+		{"origin without scheme", OriginPolicy{Allowed: []string{"app.example.com"}}, true},
+		{"scheme without host", OriginPolicy{Allowed: []string{"https://"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
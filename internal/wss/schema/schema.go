@@ -0,0 +1,208 @@
+// Package schema generates the JSON Schema document for the WebSocket protocol
+// from the Go message types, so the published schema cannot drift from the code.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
+)
+
+const draft = "https://json-schema.org/draft/2020-12/schema"
+
+// FileName returns the schema file name for a protocol version
+func FileName(version int) string {
+	return fmt.Sprintf("ws-protocol.v%d.schema.json", version)
+}
+
+// Generate builds the schema for the current protocol version as indented JSON
+func Generate() ([]byte, error) {
+	g := &generator{
+		defs:  make(map[string]any),
+		names: make(map[reflect.Type]string),
+	}
+
+	clientMessages := make([]any, 0, len(wsstypes.ClientEvents))
+	for _, ev := range wsstypes.ClientEvents {
+		clientMessages = append(clientMessages, g.clientMessage(ev))
+	}
+	serverMessages := make([]any, 0, len(wsstypes.ServerEvents))
+	for _, ev := range wsstypes.ServerEvents {
+		serverMessages = append(serverMessages, g.serverMessage(ev))
+	}
+
+	g.defs["ClientMessage"] = map[string]any{
+		"description": "A request sent by the client",
+		"oneOf":       clientMessages,
+	}
+	g.defs["ServerMessage"] = map[string]any{
+		"description": "A reply or push sent by the server; payload is set on success, error on failure",
+		"oneOf":       serverMessages,
+	}
+
+	doc := map[string]any{
+		"$schema":     draft,
+		"$id":         fmt.Sprintf("urn:challenge-wss:protocol:v%d", wsstypes.ProtocolVersion),
+		"title":       "Challenge WebSocket protocol",
+		"version":     wsstypes.ProtocolVersion,
+		"description": "Messages exchanged on /ws. Every server message uses the StandardBroadcastMessage envelope.",
+		"oneOf": []any{
+			ref("ClientMessage"),
+			ref("ServerMessage"),
+		},
+		"$defs": g.defs,
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+type generator struct {
+	defs  map[string]any
+	names map[reflect.Type]string
+}
+
+func (g *generator) clientMessage(ev wsstypes.EventSpec) map[string]any {
+	return map[string]any{
+		"description": ev.Description,
+		"type":        "object",
+		"properties": map[string]any{
+			"type":      map[string]any{"const": ev.Type},
+			"requestId": map[string]any{"type": "string", "description": "Optional correlation ID echoed on the reply"},
+			"payload":   g.payload(ev.Payload),
+		},
+		"required": []string{"type"},
+	}
+}
+
+func (g *generator) serverMessage(ev wsstypes.EventSpec) map[string]any {
+	return map[string]any{
+		"description": ev.Description,
+		"type":        "object",
+		"properties": map[string]any{
+			"type":      map[string]any{"const": ev.Type},
+			"requestId": map[string]any{"type": "string", "description": "Request ID of the request this replies to; absent on broadcasts"},
+			"seq":       map[string]any{"type": "integer", "description": "Per-challenge sequence number; set on challenge broadcasts and their RESUME replays"},
+			"payload":   nullable(g.payload(ev.Payload)),
+			"success":   map[string]any{"type": "boolean"},
+			"error":     nullable(g.schemaFor(reflect.TypeOf(wsstypes.WsError{}))),
+		},
+		"required": []string{"type", "payload", "success", "error"},
+	}
+}
+
+func (g *generator) payload(v any) map[string]any {
+	if v == nil {
+		return map[string]any{"type": "null"}
+	}
+	return g.schemaFor(reflect.TypeOf(v))
+}
+
+// schemaFor returns the schema for t; named structs are added to $defs and referenced
+func (g *generator) schemaFor(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(time.Duration(0)):
+		return map[string]any{"type": "integer", "description": "nanoseconds"}
+	case reflect.TypeOf(wsstypes.ErrorCode("")):
+		codes := make([]string, len(wsstypes.ErrorCodes))
+		for i, c := range wsstypes.ErrorCodes {
+			codes[i] = string(c)
+		}
+		return map[string]any{"type": "string", "enum": codes}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(g.schemaFor(t.Elem()))
+	case reflect.Struct:
+		return g.structRef(t)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
+func (g *generator) structRef(t reflect.Type) map[string]any {
+	if name, ok := g.names[t]; ok {
+		return ref(name)
+	}
+
+	name := t.Name()
+	if _, taken := g.defs[name]; taken || name == "" {
+		name = strings.ReplaceAll(t.String(), ".", "_")
+	}
+	// Register before recursing so self-referencing types terminate
+	g.names[t] = name
+	g.defs[name] = nil
+
+	properties := make(map[string]any)
+	required := []string{}
+	g.addFields(t, properties, &required)
+
+	g.defs[name] = map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+	return ref(name)
+}
+
+// addFields adds t's JSON fields, flattening embedded structs like encoding/json does
+func (g *generator) addFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.addFields(f.Type, properties, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		properties[name] = g.schemaFor(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/$defs/" + name}
+}
+
+func nullable(s map[string]any) map[string]any {
+	return map[string]any{"anyOf": []any{s, map[string]any{"type": "null"}}}
+}
@@ -2,75 +2,181 @@ package wss
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/global"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/broadcasts"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/client"
 	wsstypes "github.com/lijuuu/ChallengeWssManagerService/internal/wss/types"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
+func WsHandler(dispatcher *Dispatcher, state *global.State, opts Options) http.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		CheckOrigin:       opts.Origins.Check,
+		EnableCompression: opts.ClientPolicy.Compression.Enabled,
+	}
 
-func WsHandler(dispatcher *Dispatcher, state *global.State) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
+		// A token sent with the upgrade authenticates the whole connection; a bad one is rejected before upgrading
+		claims, viaBearer, err := authenticateUpgrade(r, state.JwtManager)
+		if err != nil {
+			log.Printf("[WS] upgrade auth failed from %s: %v", r.RemoteAddr, err)
+			http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+
+		var responseHeader http.Header
+		subprotocol, cd := negotiateSubprotocol(r, viaBearer)
+		if subprotocol != "" {
+			responseHeader = http.Header{"Sec-Websocket-Protocol": {subprotocol}}
+		}
+
+		conn, err := upgrader.Upgrade(client.CountWrites(w), r, responseHeader)
 		if err != nil {
 			log.Println("[WS] upgrade error:", err)
 			return
 		}
-		defer conn.Close()
-		log.Println("[WS] WebSocket connection established")
 
-		var userID, challengeID string
+		// Compression only applies if the upgrader negotiated it with this peer
+		policy := opts.ClientPolicy
+		policy.Compression.Enabled = policy.Compression.Enabled && client.OffersDeflate(r)
+
+		// All writes go through the client's write pump; this goroutine only reads
+		wsClient := client.NewClient(conn, cd, policy)
+		defer wsClient.Close()
+		log.Printf("[WS] WebSocket connection established (codec: %s, compression: %t)", cd.Name(), policy.Compression.Enabled)
+
+		// Half-open connections miss pongs, hit the read deadline and take the normal disconnect path
+		if opts.MaxMessageSize > 0 {
+			conn.SetReadLimit(opts.MaxMessageSize)
+		}
+		pongWait := opts.PongWait
+		if pongWait <= 0 {
+			pongWait = model.WebsocketReadTimeout
+		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pongWait))
+		})
+
+		// Identity is bound once, by the upgrade token or JOIN_CHALLENGE, and never taken from later payloads
+		session := &wsstypes.ConnSession{}
+		if claims != nil {
+			if _, err := session.Bind(claims.UserID, claims.ChallengeID); err != nil {
+				log.Printf("[WS] upgrade token has incomplete identity: %v", err)
+				claims = nil
+			} else {
+				log.Printf("[WS] connection authenticated at upgrade: user=%s challenge=%s", claims.UserID, claims.ChallengeID)
+			}
+		}
 
 		for {
 			_, msg, err := conn.ReadMessage()
 			if err != nil {
-				log.Printf("[WS] read error: %v (user: %s, challenge: %s)", err, userID, challengeID)
-				cleanupConnection(state, userID, challengeID)
+				userID, challengeID := session.Identity()
+				log.Printf("[WS] read error: %v (user: %s, challenge: %s, stats: %+v)", err, userID, challengeID, wsClient.Stats())
+				if state.Matchmaker != nil {
+					state.Matchmaker.RemoveClient(wsClient)
+				}
+				cleanupConnection(state, wsClient, userID, challengeID)
 				return
 			}
 
-			var wsMsg wsstypes.WsMessageRequest
-			if err := json.Unmarshal(msg, &wsMsg); err != nil {
+			wsMsg, err := DecodeMessage(cd, msg)
+			if err != nil {
 				log.Println("[WS] invalid message format:", err)
 				continue
 			}
 
 			log.Printf("[WS] received: type=%s payload=%v", wsMsg.Type, wsMsg.Payload)
 
-			// track for cleanup
-			if uid, ok := wsMsg.Payload["userId"].(string); ok {
-				userID = uid
-			}
-			if cid, ok := wsMsg.Payload["challengeId"].(string); ok {
-				challengeID = cid
+			ctx := &wsstypes.WsContext{
+				Client:    wsClient,
+				Session:   session,
+				Payload:   wsMsg.Payload,
+				EventType: wsMsg.Type,
+				State:     state,
+				Claims:    claims,
+				RequestID: wsMsg.RequestID,
 			}
 
-			ctx := &wsstypes.WsContext{
-				Conn:    conn,
-				Payload: wsMsg.Payload,
-				State:   state,
+			// Reject payloads claiming a different identity than the one bound to this connection
+			claimedUserID, _ := wsMsg.Payload["userId"].(string)
+			claimedChallengeID, _ := wsMsg.Payload["challengeId"].(string)
+			if wsMsg.Type == wsstypes.JOIN_CHALLENGE {
+				// Joining is how a connection moves to another challenge
+				claimedChallengeID = ""
+			}
+			if err := session.CheckClaim(claimedUserID, claimedChallengeID); err != nil {
+				boundUserID, boundChallengeID := session.Identity()
+				log.Printf("[WS] rejected %s: %v (bound user: %s, challenge: %s)", wsMsg.Type, err, boundUserID, boundChallengeID)
+				broadcasts.ReplyError(ctx, wsMsg.Type, wsstypes.ErrCodeForbidden, err.Error())
+				continue
 			}
 
-			if err := dispatcher.Dispatch(wsMsg.Type, ctx); err != nil {
+			_, boundChallengeID := session.Identity()
+			err = dispatcher.Dispatch(wsMsg.Type, ctx)
+			if err != nil {
 				log.Printf("[Dispatch] error handling %s: %v", wsMsg.Type, err)
 			}
+
+			// A join moved the connection to another challenge; leave the old one as on disconnect
+			if userID, challengeID := session.Identity(); boundChallengeID != "" && challengeID != boundChallengeID {
+				log.Printf("[WS] connection %s of user %s moved from challenge %s to %s", wsClient.ID(), userID, boundChallengeID, challengeID)
+				cleanupConnection(state, wsClient, userID, boundChallengeID)
+			}
+
+			// Every request gets an answer: handlers that did not reply get a generic ERROR,
+			// or an ACK when the client asked for correlation with a request ID
+			if !ctx.Replied() {
+				if errors.Is(err, ErrUnknownEvent) {
+					broadcasts.SendRequestError(ctx, wsMsg.Type, wsstypes.ErrCodeUnknownEvent, err.Error())
+				} else if err != nil {
+					broadcasts.SendRequestError(ctx, wsMsg.Type, wsstypes.ErrCodeInternal, "Request failed")
+				} else if ctx.RequestID != "" {
+					broadcasts.SendAck(ctx, wsMsg.Type)
+				}
+			}
 		}
 	}
 }
 
-func cleanupConnection(state *global.State, userID, challengeID string) {
+func cleanupConnection(state *global.State, wsClient *client.Client, userID, challengeID string) {
 	if userID == "" || challengeID == "" {
 		log.Println("[WS] skipping cleanup: userID or challengeID missing")
 		return
 	}
 
+	// Connections authenticated at upgrade may never have joined, or may have been replaced by a newer join
+	removed, remaining := state.LocalState.RemoveWSClient(challengeID, userID, wsClient)
+
+	// Replaced connections are no longer registered locally but still hold a presence entry.
+	// The cluster-wide count decides whether the user is still present on another node.
+	if state.Presence != nil {
+		clusterRemaining, err := state.Presence.Disconnect(context.Background(), challengeID, userID, wsClient.ID())
+		if err != nil {
+			log.Printf("[WS] failed to remove presence of connection %s, using local state: %v", wsClient.ID(), err)
+		} else if removed {
+			remaining = clusterRemaining
+		}
+	}
+
+	if !removed {
+		log.Printf("[WS] skipping cleanup: connection %s of user %s is not joined to challenge %s", wsClient.ID(), userID, challengeID)
+		return
+	}
+
+	// The user is still present through another tab or device, here or on another node
+	if remaining > 0 {
+		log.Printf("[WS] connection %s closed: user %s still has %d connections to challenge %s", wsClient.ID(), userID, remaining, challengeID)
+		return
+	}
+
 	log.Printf("[WS] cleaning up session: user=%s challenge=%s", userID, challengeID)
 
 	// Remove participant from Redis
@@ -80,21 +186,19 @@ func cleanupConnection(state *global.State, userID, challengeID string) {
 		log.Printf("[Redis] user %s removed from Redis for challenge %s", userID, challengeID)
 	}
 
-	// Get challenge info for broadcast before removing connection
+	// Get challenge info for broadcast before removing the session
 	challengeDoc, err := state.Redis.GetChallengeByID(context.Background(), challengeID)
 	if err != nil {
 		log.Printf("[WS] failed to get challenge for cleanup broadcast: %v", err)
 	}
 
-	// Remove WebSocket connection from local state
-	state.LocalState.RemoveWSClient(challengeID, userID)
+	// Remove session from local state
 	state.LocalState.RemoveSession(challengeID, userID)
 
 	log.Printf("[WS] user %s removed from local state for challenge %s", userID, challengeID)
 
 	// Broadcast user left to remaining clients
 	if err == nil {
-		wsClients := state.LocalState.GetAllWSClients(challengeID)
-		broadcasts.BroadcastEntityLeftWithClients(wsClients, userID, challengeID, userID == challengeDoc.CreatorID)
+		broadcasts.BroadcastEntityLeft(state.Broadcaster, state.Redis, userID, challengeID, userID == challengeDoc.CreatorID)
 	}
 }
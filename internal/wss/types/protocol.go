@@ -0,0 +1,193 @@
+package wsstypes
+
+import (
+	"time"
+
+	"github.com/lijuuu/ChallengeWssManagerService/internal/matchmaking"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+)
+
+//go:generate go run ../../../cmd/wsschema -dir ../../../docs
+
+// ProtocolVersion is the version of the WebSocket message schema. Bump it on any
+// breaking change to the envelope, an event payload or the error codes.
+const ProtocolVersion = 1
+
+// ErrorCode is a machine-readable reason carried by every failed response
+type ErrorCode string
+
+const (
+	ErrCodeAuth            ErrorCode = "AUTH_ERROR"       // missing, invalid or expired token
+	ErrCodeInvalidPayload  ErrorCode = "INVALID_PAYLOAD"  // payload failed to decode or validate
+	ErrCodeNotFound        ErrorCode = "NOT_FOUND"        // challenge does not exist
+	ErrCodeNotJoined       ErrorCode = "NOT_JOINED"       // caller has not joined the challenge
+	ErrCodeChallengeFull   ErrorCode = "CHALLENGE_FULL"   // challenge reached its max users
+	ErrCodeChallengeClosed ErrorCode = "CHALLENGE_CLOSED" // challenge is abandoned or ended
+	ErrCodeForbidden       ErrorCode = "FORBIDDEN"        // wrong password or identity mismatch
+	ErrCodeConflict        ErrorCode = "CONFLICT"         // request conflicts with current state
+	ErrCodeUnavailable     ErrorCode = "UNAVAILABLE"      // feature disabled or dependency unreachable
+	ErrCodeUnknownEvent    ErrorCode = "UNKNOWN_EVENT"    // no handler for the request type
+	ErrCodeInternal        ErrorCode = "INTERNAL"         // unexpected server error
+)
+
+// ErrorCodes lists every error code, in schema order
+var ErrorCodes = []ErrorCode{
+	ErrCodeAuth,
+	ErrCodeInvalidPayload,
+	ErrCodeNotFound,
+	ErrCodeNotJoined,
+	ErrCodeChallengeFull,
+	ErrCodeChallengeClosed,
+	ErrCodeForbidden,
+	ErrCodeConflict,
+	ErrCodeUnavailable,
+	ErrCodeUnknownEvent,
+	ErrCodeInternal,
+}
+
+// WsError is the error carried by a failed StandardBroadcastMessage
+type WsError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// Server payloads, one per outbound event
+
+// PongPayload answers PING_SERVER
+type PongPayload struct {
+	Message string `json:"message"`
+}
+
+// ChallengeStatePayload answers JOIN_CHALLENGE and RETRIEVE_CHALLENGE. Seq is the last
+// challenge broadcast at the time of the snapshot; pass it to RESUME after reconnecting.
+// Token is only set on JOIN_CHALLENGE and authenticates later requests for this challenge.
+type ChallengeStatePayload struct {
+	UserID      string                  `json:"userId"`
+	ChallengeID string                  `json:"challengeId"`
+	Challenge   model.ChallengeDocument `json:"challenge"`
+	Seq         int64                   `json:"seq"`
+	Token       string                  `json:"token,omitempty"`
+}
+
+// ResumeResult answers RESUME after the missed events have been replayed. When
+// SnapshotRequired is set nothing was replayed and the client must refetch the
+// challenge and leaderboard instead.
+type ResumeResult struct {
+	ChallengeID      string `json:"challengeId"`
+	FromSeq          int64  `json:"fromSeq"`
+	LatestSeq        int64  `json:"latestSeq"`
+	Replayed         int    `json:"replayed"`
+	SnapshotRequired bool   `json:"snapshotRequired"`
+}
+
+// PresenceResult answers PRESENCE with the users connected to the challenge on any node
+type PresenceResult struct {
+	ChallengeID string               `json:"challengeId"`
+	Users       []model.UserPresence `json:"users"`
+}
+
+// LeaderboardPayload answers CURRENT_LEADERBOARD
+type LeaderboardPayload struct {
+	ChallengeID string                    `json:"challengeId"`
+	Leaderboard []*model.LeaderboardEntry `json:"leaderboard"`
+}
+
+// QueueMatchResult answers QUEUE_MATCH
+type QueueMatchResult struct {
+	UserID  string  `json:"userId"`
+	Rating  float64 `json:"rating"`
+	Waiting int     `json:"waiting"`
+}
+
+// MatchFoundPayload is pushed when matchmaking or a rematch creates a challenge.
+// Rematch fields are only set for rematches, Preferences only for matchmaking.
+type MatchFoundPayload struct {
+	ChallengeID         string                   `json:"challengeId"`
+	PreviousChallengeID string                   `json:"previousChallengeId,omitempty"`
+	SeriesID            string                   `json:"seriesId,omitempty"`
+	CreatorID           string                   `json:"creatorId"`
+	Password            string                   `json:"password"`
+	Token               string                   `json:"token"`
+	Participants        []string                 `json:"participants"`
+	Preferences         *matchmaking.Preferences `json:"preferences,omitempty"`
+	Time                time.Time                `json:"time"`
+}
+
+// RematchStatusPayload reports rematch progress on REMATCH_REQUEST and REMATCH_ACCEPT
+type RematchStatusPayload struct {
+	ChallengeID string    `json:"challengeId"`
+	RequestedBy string    `json:"requestedBy"`
+	Accepted    []string  `json:"accepted"`
+	Required    []string  `json:"required"`
+	Ready       bool      `json:"ready"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	Time        time.Time `json:"time"`
+}
+
+// ParticipantEventPayload is broadcast when a user or owner joins or leaves, or the creator abandons
+type ParticipantEventPayload struct {
+	ChallengeID string    `json:"challengeId"`
+	UserID      string    `json:"userId"`
+	Time        time.Time `json:"time"`
+}
+
+// RatingUpdatePayload is pushed to each user when their rating changes after a challenge
+type RatingUpdatePayload struct {
+	ChallengeID string    `json:"challengeId"`
+	UserID      string    `json:"userId"`
+	OldRating   float64   `json:"oldRating"`
+	NewRating   float64   `json:"newRating"`
+	Delta       float64   `json:"delta"`
+	Rank        int       `json:"rank"`
+	Time        time.Time `json:"time"`
+}
+
+// RequestEventPayload names the request an ACK or ERROR answers
+type RequestEventPayload struct {
+	Event string `json:"event"`
+}
+
+// EventSpec describes one message type for the generated schema. Payload is a zero
+// value of the payload type, or nil for events without a payload.
+type EventSpec struct {
+	Type        string
+	Payload     any
+	Description string
+}
+
+// ClientEvents are the requests accepted by the server
+var ClientEvents = []EventSpec{
+	{PING_SERVER, nil, "Latency check"},
+	{JOIN_CHALLENGE, JoinChallengePayload{}, "Join a challenge with an API gateway token"},
+	{RETRIEVE_CHALLENGE, RetreiveChallengePayload{}, "Fetch the joined challenge's state"},
+	{CURRENT_LEADERBOARD, GetLeaderboardPayload{}, "Fetch a challenge's leaderboard"},
+	{QUEUE_MATCH, QueueMatchPayload{}, "Enter the matchmaking queue with an API gateway token"},
+	{REMATCH_REQUEST, AuthenticatedPayload{}, "Request a rematch of the ended challenge in the token"},
+	{REMATCH_ACCEPT, AuthenticatedPayload{}, "Accept a pending rematch of the ended challenge in the token"},
+	{RESUME, ResumePayload{}, "Replay the challenge broadcasts missed since lastSeq"},
+	{PRESENCE, AuthenticatedPayload{}, "List the users connected to the joined challenge"},
+}
+
+// ServerEvents are the messages sent by the server, as replies or pushes
+var ServerEvents = []EventSpec{
+	{PING_SERVER, PongPayload{}, "Reply to PING_SERVER"},
+	{JOIN_CHALLENGE, ChallengeStatePayload{}, "Reply to JOIN_CHALLENGE, with a challenge token"},
+	{RETRIEVE_CHALLENGE, ChallengeStatePayload{}, "Reply to RETRIEVE_CHALLENGE"},
+	{CURRENT_LEADERBOARD, LeaderboardPayload{}, "Reply to CURRENT_LEADERBOARD"},
+	{QUEUE_MATCH, QueueMatchResult{}, "Reply to QUEUE_MATCH"},
+	{MATCH_FOUND, MatchFoundPayload{}, "A match or rematch challenge was created"},
+	{REMATCH_REQUEST, RematchStatusPayload{}, "Rematch requested; broadcast to prior participants"},
+	{REMATCH_ACCEPT, RematchStatusPayload{}, "Rematch accepted; broadcast to prior participants"},
+	{USER_JOINED, ParticipantEventPayload{}, "A participant joined"},
+	{OWNER_JOINED, ParticipantEventPayload{}, "The creator joined"},
+	{USER_LEFT, ParticipantEventPayload{}, "A participant left"},
+	{OWNER_LEFT, ParticipantEventPayload{}, "The creator left"},
+	{CREATOR_ABANDON, ParticipantEventPayload{}, "The creator abandoned the challenge"},
+	{NEW_SUBMISSION, NewSubmissionPayload{}, "A participant solved a problem"},
+	{LEADERBOARD_UPDATE, LeaderboardUpdatePayload{}, "The leaderboard changed; may be coalesced for slow clients"},
+	{RATING_UPDATE, RatingUpdatePayload{}, "The user's rating changed after a challenge"},
+	{RESUME, ResumeResult{}, "Reply to RESUME, sent after the replayed events"},
+	{PRESENCE, PresenceResult{}, "Reply to PRESENCE"},
+	{ACK, RequestEventPayload{}, "A request with a requestId completed without a direct reply"},
+	{ERROR, RequestEventPayload{}, "A request failed without a direct reply"},
+}
@@ -1,35 +1,134 @@
 package wsstypes
 
 import (
+	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/constants"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/global"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/jwt"
 	"github.com/lijuuu/ChallengeWssManagerService/internal/model"
+	"github.com/lijuuu/ChallengeWssManagerService/internal/wss/client"
 )
 
 type WsContext struct {
-	Conn      *websocket.Conn
+	Client    *client.Client
+	Session   *ConnSession
 	Payload   map[string]any
 	UserID    string
 	EventType string
 	State     *global.State
 	Claims    *jwt.CustomClaims
+	RequestID string // client-supplied correlation ID, echoed on direct replies
+
+	replied atomic.Bool
+}
+
+// MarkReplied records that a direct reply was sent for this request
+func (ctx *WsContext) MarkReplied() {
+	ctx.replied.Store(true)
+}
+
+// Replied reports whether a direct reply was sent for this request
+func (ctx *WsContext) Replied() bool {
+	return ctx.replied.Load()
+}
+
+// Identity returns the user and challenge this request acts as: the connection's
+// bound session if there is one, otherwise the validated JWT claims
+func (ctx *WsContext) Identity() (string, string) {
+	if ctx.Session != nil && ctx.Session.IsBound() {
+		return ctx.Session.Identity()
+	}
+	if ctx.Claims != nil {
+		return ctx.Claims.UserID, ctx.Claims.ChallengeID
+	}
+	return "", ""
+}
+
+// ConnSession is the identity bound to a single WebSocket connection. It is set on a
+// successful JOIN_CHALLENGE, and every later message on the connection acts as it. The
+// user never changes, but a later join moves the connection to another challenge, so a
+// socket can follow its user into a matched challenge or a rematch.
+type ConnSession struct {
+	userID      string
+	challengeID string
+	mu          sync.RWMutex
+}
+
+// Bind binds the connection to a user and challenge and returns the challenge it was
+// bound to before, empty if none. Binding the same user to another challenge moves the
+// connection there; binding a different user is rejected.
+func (s *ConnSession) Bind(userID, challengeID string) (previous string, err error) {
+	if userID == "" || challengeID == "" {
+		return "", errors.New("userID and challengeID are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.userID != "" && s.userID != userID {
+		return "", errors.New("connection is already bound to another user")
+	}
+
+	previous = s.challengeID
+	s.userID = userID
+	s.challengeID = challengeID
+	return previous, nil
+}
+
+// Identity returns the bound user and challenge, empty if unbound
+func (s *ConnSession) Identity() (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.userID, s.challengeID
+}
+
+// IsBound reports whether the connection has been bound to an identity
+func (s *ConnSession) IsBound() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.userID != ""
+}
+
+// CheckClaim rejects a user or challenge ID that differs from the bound identity.
+// Empty values and unbound sessions always pass.
+func (s *ConnSession) CheckClaim(userID, challengeID string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.userID == "" {
+		return nil
+	}
+	if userID != "" && userID != s.userID {
+		return errors.New("user does not match this connection")
+	}
+	if challengeID != "" && challengeID != s.challengeID {
+		return errors.New("challenge does not match this connection")
+	}
+	return nil
 }
 
 type WsMessageRequest struct {
-	Type    string         `json:"type"`
-	Payload map[string]any `json:"payload"`
+	Type      string         `json:"type"`
+	RequestID string         `json:"requestId,omitempty"`
+	Payload   map[string]any `json:"payload"`
 }
 
-// StandardBroadcastMessage provides a consistent format for all WebSocket broadcasts
+// StandardBroadcastMessage is the envelope of every message the server sends, both
+// replies and broadcasts. Payload holds the event's typed payload (see ServerEvents)
+// on success; Error is set on failure.
 type StandardBroadcastMessage struct {
-	Type    string  `json:"type"`
-	Payload any     `json:"payload"`
-	Success bool    `json:"success"`
-	Error   *string `json:"error"`
+	Type      string   `json:"type"`
+	RequestID string   `json:"requestId,omitempty"`
+	Seq       int64    `json:"seq,omitempty"` // per-challenge sequence number, set on challenge broadcasts
+	Payload   any      `json:"payload"`
+	Success   bool     `json:"success"`
+	Error     *WsError `json:"error"`
 }
 
 type JoinChallengePayload struct {
@@ -40,24 +139,40 @@ type JoinChallengePayload struct {
 	Token       string `json:"token"`
 }
 
+type QueueMatchPayload struct {
+	UserId             string `json:"userId"`
+	Type               string `json:"type"`
+	Token              string `json:"token"`
+	Size               int    `json:"size"`
+	MaxEasyQuestions   int    `json:"maxEasyQuestions"`
+	MaxMediumQuestions int    `json:"maxMediumQuestions"`
+	MaxHardQuestions   int    `json:"maxHardQuestions"`
+}
+
 type RetreiveChallengePayload struct {
 	UserId      string `json:"userId"`
 	Type        string `json:"type"`
 	ChallengeId string `json:"challengeId"`
+	Token       string `json:"token,omitempty"` // optional when authenticated at upgrade
+}
+
+type GetLeaderboardPayload struct {
+	UserId      string `json:"userId"`
+	Type        string `json:"type"`
+	ChallengeId string `json:"challengeId"`
+	Limit       int    `json:"limit,omitempty"` // Optional limit, defaults to 100
+	Token       string `json:"token,omitempty"` // optional when authenticated at upgrade
 }
 
-type GenericResponse struct {
-	Success bool           `json:"success"`
-	Status  int            `json:"status"`
-	Payload map[string]any `json:"payload"`
-	Error   *ErrorInfo     `json:"error"`
+// ResumePayload asks for the challenge broadcasts after LastSeq
+type ResumePayload struct {
+	LastSeq int64  `json:"lastSeq"`
+	Token   string `json:"token,omitempty"` // optional when authenticated at upgrade
 }
 
-type ErrorInfo struct {
-	ErrorType string `json:"errorType"`
-	Code      int    `json:"code"`
-	Message   string `json:"message"`
-	Details   string `json:"details"`
+// AuthenticatedPayload is the payload of requests that carry nothing but the challenge token
+type AuthenticatedPayload struct {
+	Token string `json:"token,omitempty"` // optional when authenticated at upgrade
 }
 
 // Enhanced payload types that include challenge document data directly
@@ -97,4 +212,13 @@ const (
 	CURRENT_LEADERBOARD = constants.CURRENT_LEADERBOARD
 	LEADERBOARD_UPDATE  = constants.LEADERBOARD_UPDATE
 	NEW_SUBMISSION      = constants.NEW_SUBMISSION
+	RATING_UPDATE       = constants.RATING_UPDATE
+	QUEUE_MATCH         = constants.QUEUE_MATCH
+	MATCH_FOUND         = constants.MATCH_FOUND
+	REMATCH_REQUEST     = constants.REMATCH_REQUEST
+	REMATCH_ACCEPT      = constants.REMATCH_ACCEPT
+	RESUME              = constants.RESUME
+	PRESENCE            = constants.PRESENCE
+	ACK                 = constants.ACK
+	ERROR               = constants.ERROR
 )